@@ -14,7 +14,10 @@ func newTestCache(t *testing.T, slots int64, recordSize int) (*RingBufferCache,
 	return newTestCacheWithOpts(t, slots, recordSize, DefaultOptions())
 }
 
-func newTestCacheWithOpts(t *testing.T, totalSlots int64, recordSize int, opts CacheOptions) (*RingBufferCache, string) {
+// newTestCacheWithOpts is newTestCache with opts as the starting point. The
+// cache uses one shard without mmap or prefetch unless overrides, applied
+// last, say otherwise.
+func newTestCacheWithOpts(t *testing.T, totalSlots int64, recordSize int, opts CacheOptions, overrides ...func(*CacheOptions)) (*RingBufferCache, string) {
 	t.Helper()
 	dir := t.TempDir()
 	base := filepath.Join(dir, "cache.data")
@@ -30,6 +33,9 @@ func newTestCacheWithOpts(t *testing.T, totalSlots int64, recordSize int, opts C
 	}
 	opts.BufferPoolSize = 10
 	opts.PrefetchSize = 0
+	for _, o := range overrides {
+		o(&opts)
+	}
 
 	c, err := NewRingBufferCacheWithOptions(base, opts)
 	if err != nil {
@@ -38,6 +44,21 @@ func newTestCacheWithOpts(t *testing.T, totalSlots int64, recordSize int, opts C
 	return c, base
 }
 
+// withShards spreads a test cache over n shard files.
+func withShards(n int) func(*CacheOptions) {
+	return func(o *CacheOptions) { o.ShardCount = n }
+}
+
+// withMmap sets whether a test cache maps its shard files.
+func withMmap(useMmap bool) func(*CacheOptions) {
+	return func(o *CacheOptions) { o.UseMmap = useMmap }
+}
+
+// withPrefetch enables prefetching of size records with the given workers.
+func withPrefetch(size, workers int) func(*CacheOptions) {
+	return func(o *CacheOptions) { o.PrefetchSize, o.PrefetchWorkers = size, workers }
+}
+
 func TestWriteRead(t *testing.T) {
 	const (
 		size       = 100
@@ -0,0 +1,217 @@
+package archive
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// dirtyRange is the byte range of one shard touched by a write.
+type dirtyRange struct {
+	s      *shard
+	lo, hi int64
+}
+
+// dirtySet collects the ranges to make durable after a write, one per shard.
+type dirtySet []dirtyRange
+
+func (d *dirtySet) add(s *shard, off, n int64) {
+	for i := range *d {
+		r := &(*d)[i]
+		if r.s == s {
+			r.lo, r.hi = min(r.lo, off), max(r.hi, off+n)
+			return
+		}
+	}
+	*d = append(*d, dirtyRange{s: s, lo: off, hi: off + n})
+}
+
+// syncDirty makes the ranges in d durable. With GroupCommit concurrent
+// callers share one sync of the whole shard; otherwise mmap shards msync
+// only the touched pages.
+func (c *RingBufferCache) syncDirty(d dirtySet) error {
+	for _, r := range d {
+		var err error
+		if c.options.GroupCommit {
+			err = r.s.group.do(r.s.sync)
+		} else {
+			err = r.s.syncRange(r.lo, r.hi)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncRange flushes bytes lo..hi of the shard. msync works on whole pages,
+// so lo is rounded down to a page boundary; plain files are fsynced whole.
+func (s *shard) syncRange(lo, hi int64) error {
+	if s.mmap == nil {
+		return s.sync()
+	}
+	lo &^= int64(os.Getpagesize() - 1)
+	if err := unix.Msync(s.mmap[lo:hi], unix.MS_SYNC); err != nil {
+		return &ShardError{Op: "msync", Shard: s.index, Path: s.filePath, Err: err}
+	}
+	return nil
+}
+
+// groupCommit lets concurrent writers share one sync. A caller whose data
+// was written before a running sync started is covered by that sync;
+// everybody else waits for it to finish and then one of them runs the next.
+type groupCommit struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	requested uint64 // tickets handed out
+	synced    uint64 // highest ticket covered by a finished sync
+	running   bool
+	err       error // result of the last finished sync
+}
+
+func (g *groupCommit) do(fn func() error) error {
+	g.mu.Lock()
+	if g.cond == nil {
+		g.cond = sync.NewCond(&g.mu)
+	}
+	g.requested++
+	ticket := g.requested
+	for g.running && g.synced < ticket {
+		g.cond.Wait()
+	}
+	if g.synced >= ticket {
+		// a later sync also covers our data, so its result applies to us
+		err := g.err
+		g.mu.Unlock()
+		return err
+	}
+
+	g.running = true
+	target := g.requested
+	g.mu.Unlock()
+
+	err := fn()
+
+	g.mu.Lock()
+	g.running = false
+	g.synced, g.err = target, err
+	g.cond.Broadcast()
+	g.mu.Unlock()
+	return err
+}
+
+// writeRun writes payloads to consecutive slots starting at id, wrapping at
+// MaxIDAlloc. All slots are locked together and encoded into one buffer, so
+// each contiguous shard run costs one copy into the mapping or one pwrite.
+func (c *RingBufferCache) writeRun(id int64, payloads [][]byte, flush bool, ts int64) error {
+	payloads, err := c.sealRecords(id, payloads)
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, p := range payloads {
+		total += c.slotsFor(len(p))
+	}
+	if total > c.size {
+		return fmt.Errorf("%w: batch needs %d slots, cache has %d", ErrPayloadSize, total, c.size)
+	}
+	ids := c.spanIDs(id, total)
+	unlock := c.lockSpan(ids, true)
+	defer unlock()
+
+	rec := int64(c.diskRec)
+	buf := make([]byte, total*rec)
+	k := int64(0)
+	for _, p := range payloads {
+		n := c.slotsFor(len(p))
+		for i := int64(0); i < n; i++ {
+			from := int(i) * c.record
+			chunk := p[from:min(from+c.record, len(p))]
+			h := slotHeader{length: len(p) - from, seq: c.seqForID(ids[k]), ts: ts}
+			if i > 0 {
+				h.flags = flagContinuation
+			}
+			c.encodeSlot(buf[k*rec:(k+1)*rec], chunk, h)
+			k++
+		}
+	}
+
+	var dirty dirtySet
+	for start := int64(0); start < total; {
+		s, off, err := c.slotAt(ids[start])
+		if err != nil {
+			return err
+		}
+		// extend the run while the next slot follows in the same shard
+		end := start + 1
+		for end < total && ids[end] == ids[end-1]+1 && ids[end]-c.minIDAlloc < s.offset+s.size {
+			end++
+		}
+		if err := s.writeSlot(buf[start*rec:end*rec], off); err != nil {
+			return c.recordErr(ids[start], s, off, err)
+		}
+		dirty.add(s, off, (end-start)*rec)
+		start = end
+	}
+
+	if flush {
+		return c.syncDirty(dirty)
+	}
+	return nil
+}
+
+// WriteHeadBatch appends payloads after head as one batch and returns the ID
+// of each record. The slots are claimed, written with one write per shard
+// run and published to subscribers together; with flush the touched range
+// and .meta are synced once for the whole batch.
+func (c *RingBufferCache) WriteHeadBatch(payloads [][]byte, flush bool) ([]int64, error) {
+	if err := c.enterWrite(); err != nil {
+		return nil, err
+	}
+	defer c.exit()
+	payloads, err := c.encodeRecords(payloads)
+	if err != nil {
+		return nil, err
+	}
+	var total int64
+	for i, p := range payloads {
+		n, err := c.checkPayload(c.sealedLen(len(p)))
+		if err != nil {
+			return nil, fmt.Errorf("payload %d: %w", i, err)
+		}
+		total += n
+	}
+	if len(payloads) == 0 {
+		return nil, nil
+	}
+	if total > c.size {
+		return nil, fmt.Errorf("%w: batch needs %d slots, cache has %d", ErrPayloadSize, total, c.size)
+	}
+
+	flush = c.wantSync(flush)
+	first, last := c.claim(total)
+	ids := make([]int64, len(payloads))
+	seq := first
+	for i, p := range payloads {
+		ids[i] = c.idForSeq(seq)
+		seq += uint64(c.slotsFor(c.sealedLen(len(p))))
+	}
+
+	err = c.writeRun(ids[0], payloads, flush, c.stamp())
+	c.commitHead(first, last)
+	if err != nil {
+		return nil, err
+	}
+
+	if flush {
+		if err := c.saveMeta(c.metaState(false)); err != nil {
+			return ids, fmt.Errorf("save meta: %w", err)
+		}
+		c.markDurable(first, last)
+	} else {
+		c.noteUnsynced(len(payloads))
+	}
+	return ids, nil
+}
@@ -0,0 +1,103 @@
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWriteHeadBatchWrapsAcrossShards(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxIDAlloc = 10
+	opts.VariableLength = true
+	opts.Sequenced = true
+	cache, _ := newTestCacheWithOpts(t, 10, 8, opts, withShards(3), withMmap(true))
+	defer cache.Close()
+	for i := 0; i < 7; i++ {
+		cache.WriteHead([]byte{byte(i)}, false)
+	}
+
+	payloads := [][]byte{
+		bytes.Repeat([]byte{'a'}, 20), // slots 8-10
+		[]byte("b"),                   // slot 1 after wrap
+		bytes.Repeat([]byte{'c'}, 9),  // slots 2-3
+	}
+	ids, err := cache.WriteHeadBatch(payloads, true)
+	if err != nil {
+		t.Fatalf("batch: %v", err)
+	}
+	if want := []int64{8, 1, 2}; len(ids) != 3 || ids[0] != want[0] || ids[1] != want[1] || ids[2] != want[2] {
+		t.Fatalf("ids = %v, want %v", ids, want)
+	}
+	if cache.Head() != 3 || cache.Seq() != 13 {
+		t.Fatalf("head=%d seq=%d after batch", cache.Head(), cache.Seq())
+	}
+	for i, id := range ids {
+		got, err := cache.Read(id)
+		if err != nil || !bytes.Equal(got, payloads[i]) {
+			t.Fatalf("read %d: %q %v", id, got, err)
+		}
+	}
+	if got, err := cache.ReadSeq(11); err != nil || !bytes.Equal(got, payloads[1]) {
+		t.Fatalf("ReadSeq(11): %q %v", got, err)
+	}
+	if _, err := cache.WriteHeadBatch([][]byte{make([]byte, 88)}, false); !errors.Is(err, ErrPayloadSize) {
+		t.Fatalf("oversized batch: %v", err)
+	}
+}
+
+func TestBulkWriteSingleRun(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxIDAlloc = 10
+	cache, _ := newTestCacheWithOpts(t, 10, 8, opts, withShards(3), withMmap(true))
+	defer cache.Close()
+	payloads := make([][]byte, 10)
+	for i := range payloads {
+		payloads[i] = bytes.Repeat([]byte{byte('0' + i)}, 8)
+	}
+	if err := cache.BulkWrite(1, payloads, true); err != nil {
+		t.Fatalf("bulk write: %v", err)
+	}
+	got, err := cache.BulkRead(1, 10)
+	if err != nil {
+		t.Fatalf("bulk read: %v", err)
+	}
+	for i := range payloads {
+		if !bytes.Equal(got[i], payloads[i]) {
+			t.Fatalf("record %d = %q", i+1, got[i])
+		}
+	}
+}
+
+func TestGroupCommitSharesSync(t *testing.T) {
+	var g groupCommit
+	var calls int32
+	slowSync := func() error {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := g.do(slowSync); err != nil {
+				t.Errorf("do: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&calls); n >= 32 || n < 1 {
+		t.Fatalf("expected shared syncs, got %d for 32 callers", n)
+	}
+
+	boom := errors.New("boom")
+	if err := g.do(func() error { return boom }); err != boom {
+		t.Fatalf("sync error not propagated: %v", err)
+	}
+}
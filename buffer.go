@@ -1,22 +1,27 @@
 package archive
 
-import "sync"
+import (
+	"slices"
+	"sync"
+)
 
 // getBufFromPool mengambil buffer dari pool atau membuat baru jika tidak tersedia.
-// Ukuran buffer selalu c.diskRec byte (CRC + payload).
-func (c *RingBufferCache) getBufFromPool() []byte {
+// Ukuran buffer selalu c.diskRec byte (CRC + payload). Pool menyimpan pointer
+// ke slice agar Put tidak mengalokasikan ulang header slice.
+func (c *RingBufferCache) getBufFromPool() *[]byte {
 	if c.bufPool != nil {
-		return c.bufPool.Get().([]byte)
+		return c.bufPool.Get().(*[]byte)
 	}
-	return make([]byte, c.diskRec)
+	buf := make([]byte, c.diskRec)
+	return &buf
 }
 
 // returnBufToPool mengembalikan buffer ke pool untuk digunakan kembali.
 // Hanya buffer dengan ukuran tepat yang akan dimasukkan kembali ke pool untuk
 // menghindari fragmentasi.
-func (c *RingBufferCache) returnBufToPool(buf []byte) {
-	if c.bufPool != nil && len(buf) == c.diskRec {
-		c.bufPool.Put(buf)
+func (c *RingBufferCache) returnBufToPool(bp *[]byte) {
+	if c.bufPool != nil && len(*bp) == c.diskRec {
+		c.bufPool.Put(bp)
 	}
 }
 
@@ -25,3 +30,31 @@ func (c *RingBufferCache) returnBufToPool(buf []byte) {
 func (c *RingBufferCache) lock(id int64) *sync.RWMutex {
 	return &c.locks[id%int64(c.nLock)]
 }
+
+// lockSpan mengunci semua mutex yang mencakup ids dalam urutan indeks naik
+// sehingga operasi multi-slot yang berjalan bersamaan tidak saling deadlock.
+// Mengembalikan fungsi untuk melepas kunci.
+func (c *RingBufferCache) lockSpan(ids []int64, write bool) func() {
+	idx := make([]int, len(ids))
+	for i, id := range ids {
+		idx[i] = int(id % int64(c.nLock))
+	}
+	slices.Sort(idx)
+	idx = slices.Compact(idx)
+	for _, i := range idx {
+		if write {
+			c.locks[i].Lock()
+		} else {
+			c.locks[i].RLock()
+		}
+	}
+	return func() {
+		for _, i := range idx {
+			if write {
+				c.locks[i].Unlock()
+			} else {
+				c.locks[i].RUnlock()
+			}
+		}
+	}
+}
@@ -1,14 +1,12 @@
 package archive
 
 import (
+	"errors"
 	"fmt"
-	"log"
 	"os"
-	"path/filepath"
 	"sync"
 	"sync/atomic"
-
-	"golang.org/x/sys/unix"
+	"time"
 )
 
 // RingBufferCache menyediakan implementasi ring buffer berbasis file dengan
@@ -19,21 +17,63 @@ type RingBufferCache struct {
 	shards  []*shard       // Daftar file shards (selalu >=1)
 	size    int64          // Jumlah slot ID total (basis 1)
 	record  int            // Ukuran payload publik
-	diskRec int            // Ukuran sebenarnya di disk = record + 4 (CRC)
+	diskRec int            // Ukuran sebenarnya di disk = header + record
+	layout  slotLayout     // Susunan header tiap slot
+	codec   Codec          // Kompresi payload (nil = disimpan apa adanya)
+	cipher  *Cipher        // Enkripsi payload (nil = plaintext)
+	gens    *generations   // Generasi nonce untuk cipher (nil untuk reader)
 	locks   []sync.RWMutex // Sharded locks
 	nLock   int            // Total mutex shards
 	options CacheOptions
 	bufPool *sync.Pool // Pool untuk reuse buffer
 
 	// ring buffer meta
-	head         uint64 // last written id
-	tail         uint64 // oldest valid id (future use)
-	minIDAlloc   int64
-	maxIDAlloc   uint64
-	metaPath     string
-	writerActive uint32
+	head        uint64        // head as loaded from .meta; Head derives it from commitSeq
+	tail        uint64        // tail as loaded from .meta; Tail derives it from seq
+	seq         uint64        // logical write count: sequence of the last claimed slot
+	commitSeq   uint64        // last sequence fully written by WriteHead
+	trimSeq     uint64        // records up to this sequence were expired by retention
+	durableSeq  uint64        // last sequence known to be synced (see LastDurableID)
+	unsynced    int64         // records written since the last background sync
+	flushKick   chan struct{} // wakes the DurabilityEveryN flusher
+	flushErrors uint64        // failed background syncs
+	trimErrors  uint64        // failed retention passes
+	lastTS      int64         // newest timestamp handed out (Timestamped caches)
+	now         func() time.Time
+	minIDAlloc  int64
+	maxIDAlloc  uint64
+	basePath    string
+	metaPath    string
+	metaMu      sync.Mutex // serialises .meta writes
+	metaGen     uint64     // generation of the last .meta copy written
+
+	prefetcher *prefetcher // worker pool read-ahead (nil bila PrefetchSize = 0)
+
+	closeMu  sync.Mutex    // protects closed, inflight and resizing
+	drained  sync.Cond     // signalled when inflight drops to zero or a pause ends
+	inflight int           // public operations currently running
+	closed   bool          // set by Close; new operations fail with ErrClosed
+	resizing bool          // set while Resize or a layout reload holds operations off
+	done     chan struct{} // closed by Close to stop background goroutines
+
+	layoutMu    sync.RWMutex // guards the ring geometry for accessors that do not enter
+	layoutEpoch uint32       // shared layout epoch the shards belong to (readers)
+
+	commitMu  sync.Mutex // orders commitHead calls
+	committed sync.Cond  // signalled when commitSeq advances
+
+	notifyMu  sync.Mutex    // protects notifyCh and watchDone
+	notifyCh  chan struct{} // closed on every WriteHead commit (nil = no waiters)
+	watchDone chan struct{} // closed when watchShared exits (nil = not started)
+
+	cursorMu sync.Mutex         // protects cursors
+	cursors  map[string]*Cursor // named consumer cursors opened so far
 
-	prefetchMap *sync.Map // Map[id]bool untuk menandai data yang diprefetch
+	recovery RecoveryReport // outcome of the open-time recovery pass
+
+	lockFile *os.File      // holds the writer flock (nil for readers)
+	shared   *sharedHeader // live head/tail shared with read-only processes
+	readOnly bool          // opened with OpenReadOnly
 
 	statMisses uint64 // statistik miss (access atau CRC corrupt)
 	statHits   uint64 // statistik hit
@@ -52,147 +92,178 @@ func NewRingBufferCache(path string, opts CacheOptions) (*RingBufferCache, error
 // Returns:
 //   - A pointer to the created RingBufferCache.
 //   - An error if initialization fails, including directory creation, file opening, or memory mapping.
+//
+// Only one writer may have a cache open at a time: the constructor takes an
+// exclusive flock on <basePath>.lock and fails with ErrLocked while another
+// process (or another open in this one) holds it. Use OpenReadOnly to read
+// from other processes.
 func NewRingBufferCacheWithOptions(basePath string, opts CacheOptions) (*RingBufferCache, error) {
+	cache, err := openWriter(basePath, opts)
+	if err != nil {
+		return nil, err
+	}
+	// the writer may change any slot, so a checkpoint of an interrupted
+	// Reshard no longer matches the cache
+	if err := discardReshard(basePath); err != nil {
+		cache.Close()
+		return nil, fmt.Errorf("discard reshard checkpoint: %w", err)
+	}
+	return cache, nil
+}
+
+// openCache opens the cache files at basePath. The caller holds the writer
+// lock unless readOnly is set.
+func openCache(basePath string, opts CacheOptions, readOnly bool) (*RingBufferCache, error) {
 	if opts.RecordSize <= 0 {
-		return nil, fmt.Errorf("RecordSize harus positif")
+		return nil, fmt.Errorf("RecordSize must be positive")
 	}
 	if opts.MaxIDAlloc <= opts.MinIDAlloc {
-		return nil, fmt.Errorf("MaxIDAlloc harus > MinIDAlloc")
+		return nil, fmt.Errorf("MaxIDAlloc must be greater than MinIDAlloc")
 	}
 
-	size := int64(opts.MaxIDAlloc - opts.MinIDAlloc + 1)
-	recordSize := opts.RecordSize
-
-	diskRec := recordSize + 4 // +4 byte CRC32
+	if opts.Checksum < ChecksumIEEE || opts.Checksum > ChecksumNone {
+		return nil, fmt.Errorf("unknown Checksum %v", opts.Checksum)
+	}
+	if opts.Retention.MaxAge < 0 || opts.Retention.MaxRecords < 0 {
+		return nil, fmt.Errorf("Retention limits must not be negative")
+	}
+	if err := opts.Durability.validate(); err != nil {
+		return nil, err
+	}
 
 	// Tentukan nilai default opsi
 	if opts.ShardCount <= 0 {
 		opts.ShardCount = 1
 	}
 
-	// Hitung ukuran shard default bila multi-shard
-	shardSize := size
-	if opts.ShardCount > 1 {
-		shardSize = size / int64(opts.ShardCount)
-		if size%int64(opts.ShardCount) != 0 {
-			shardSize++ // round-up
-		}
-	}
-
-	// Pastikan direktori ada
-	if err := os.MkdirAll(filepath.Dir(basePath), 0o755); err != nil {
-		return nil, fmt.Errorf("gagal membuat direktori: %w", err)
-	}
-
-	// verifikasi konfigurasi persist
 	configPath := basePath + ".cfg"
-	if err := verifyOrWriteConfig(configPath, &opts); err != nil {
-		log.Printf("[archive] configuration mismatch: %v", err)
-		panic(err)
-	}
-
-	// Inisialisasi shards
-	shards := make([]*shard, opts.ShardCount)
-	var offset int64
-
-	for i := 0; i < opts.ShardCount; i++ {
-		currentShardSize := shardSize
-		if i == opts.ShardCount-1 {
-			currentShardSize = size - offset // shard terakhir mungkin lebih kecil
-		}
-
-		shardPath := basePath
-		if opts.ShardCount > 1 {
-			shardPath = fmt.Sprintf("%s.%d", basePath, i)
+	fresh := false
+	if !readOnly { // reader memakai layout .cfg yang sudah diterapkan OpenReadOnly
+		// selesaikan migrasi yang terputus sebelum membaca konfigurasi
+		if err := resumeSwap(basePath); err != nil {
+			return nil, fmt.Errorf("resume migration: %w", err)
 		}
 
-		f, err := os.OpenFile(shardPath, os.O_RDWR|os.O_CREATE, 0o666)
-		if err != nil {
-			// cleanup opened shards
-			for j := 0; j < i; j++ {
-				shards[j].file.Close()
-				if shards[j].mmap != nil {
-					unix.Munmap(shards[j].mmap)
+		// verifikasi konfigurasi persist
+		_, statErr := os.Stat(configPath)
+		fresh = os.IsNotExist(statErr) // cache baru, tidak perlu recovery
+		if err := verifyOrWriteConfig(configPath, &opts); err != nil {
+			var mismatch *ConfigMismatchError
+			if opts.ConfigPolicy == ConfigMigrate && errors.As(err, &mismatch) {
+				if err := migrate(basePath, opts); err != nil {
+					return nil, fmt.Errorf("migrate %s: %w", basePath, err)
 				}
+				return openCache(basePath, opts, false)
 			}
-			return nil, fmt.Errorf("gagal membuka shard %d: %w", i, err)
-		}
-
-		diskSize := currentShardSize * int64(diskRec)
-		if err := f.Truncate(diskSize); err != nil {
-			f.Close()
-			for j := 0; j < i; j++ {
-				shards[j].file.Close()
-				if shards[j].mmap != nil {
-					unix.Munmap(shards[j].mmap)
-				}
-			}
-			return nil, fmt.Errorf("gagal mengalokasikan shard %d: %w", i, err)
+			return nil, err
 		}
+	}
 
-		s := &shard{
-			file:     f,
-			filePath: shardPath,
-			size:     currentShardSize,
-			offset:   offset,
+	if opts.Retention.MaxAge > 0 && !opts.Timestamped {
+		return nil, fmt.Errorf("Retention.MaxAge requires Timestamped")
+	}
+	codec := opts.Codec
+	if codecName(codec) == "" {
+		codec = nil
+	} else if !opts.VariableLength {
+		return nil, fmt.Errorf("Codec requires VariableLength")
+	}
+	var gens *generations
+	if opts.Cipher != nil {
+		if !opts.VariableLength {
+			return nil, fmt.Errorf("Cipher requires VariableLength")
 		}
-
-		if opts.UseMmap {
-			mmap, err := unix.Mmap(int(f.Fd()), 0, int(diskSize), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+		if !readOnly {
+			if _, _, err := opts.Cipher.current(); err != nil {
+				return nil, fmt.Errorf("cipher: %w", err)
+			}
+			g, err := loadGenerations(genPath(basePath))
 			if err != nil {
-				f.Close()
-				for j := 0; j < i; j++ {
-					shards[j].file.Close()
-					if shards[j].mmap != nil {
-						unix.Munmap(shards[j].mmap)
-					}
-				}
-				return nil, fmt.Errorf("gagal mmap shard %d: %w", i, err)
+				return nil, err
 			}
-			s.mmap = mmap
+			gens = g
 		}
+	}
 
-		shards[i] = s
-		offset += currentShardSize
+	size := int64(opts.MaxIDAlloc - opts.MinIDAlloc + 1)
+	recordSize := opts.RecordSize
+
+	layout := newSlotLayout(opts)
+	diskRec := layout.hdrSize + recordSize // header (checksum [+ info]) + payload
+
+	shards, err := openShards(basePath, opts, size, diskRec, readOnly)
+	if err != nil {
+		return nil, err
 	}
 
 	// Buffer pool
 	var pool *sync.Pool
 	if opts.BufferPoolSize > 0 {
-		pool = &sync.Pool{New: func() any { return make([]byte, diskRec) }}
+		pool = &sync.Pool{New: func() any {
+			buf := make([]byte, diskRec)
+			return &buf
+		}}
 	}
 
 	nLocks := 256
 	locks := make([]sync.RWMutex, nLocks)
 
 	cache := &RingBufferCache{
-		shards:      shards,
-		size:        size,
-		record:      recordSize,
-		diskRec:     diskRec,
-		locks:       locks,
-		nLock:       nLocks,
-		options:     opts,
-		minIDAlloc:  opts.MinIDAlloc,
-		maxIDAlloc:  uint64(opts.MaxIDAlloc),
-		bufPool:     pool,
-		prefetchMap: &sync.Map{},
-		metaPath:    metaPath(basePath),
-	}
-
-	// load meta if exists, otherwise set initial head/tail
-	if h, t, err := loadMeta(cache.metaPath); err == nil {
-		atomic.StoreUint64(&cache.head, h)
-		atomic.StoreUint64(&cache.tail, t)
-	} else {
-		// fresh cache
-		start := uint64(cache.minIDAlloc)
-		if start == 0 {
-			start = 1
+		shards:     shards,
+		size:       size,
+		record:     recordSize,
+		diskRec:    diskRec,
+		layout:     layout,
+		codec:      codec,
+		cipher:     opts.Cipher,
+		gens:       gens,
+		locks:      locks,
+		nLock:      nLocks,
+		options:    opts,
+		minIDAlloc: opts.MinIDAlloc,
+		maxIDAlloc: uint64(opts.MaxIDAlloc),
+		bufPool:    pool,
+		basePath:   basePath,
+		metaPath:   metaPath(basePath),
+		done:       make(chan struct{}),
+		now:        time.Now,
+	}
+	cache.drained.L = &cache.closeMu
+	cache.committed.L = &cache.commitMu
+
+	if readOnly {
+		cache.readOnly = true
+		if err := cache.attachReader(); err != nil {
+			closeShards(shards)
+			return nil, err
+		}
+		cache.prefetcher = cache.startPrefetcher()
+		return cache, nil
+	}
+
+	// load meta; a cache that was not closed cleanly is recovered by
+	// scanning its slots
+	report, err := cache.loadOrRecover(fresh)
+	if err != nil {
+		closeShards(shards)
+		return nil, fmt.Errorf("recover: %w", err)
+	}
+	cache.recovery = report
+	atomic.StoreUint64(&cache.durableSeq, atomic.LoadUint64(&cache.seq))
+	if seq := cache.Seq(); layout.tsOff > 0 && seq > 0 {
+		// keep timestamps monotonic across restarts
+		if h, err := cache.headerAt(seq); err == nil {
+			cache.lastTS = h.ts
 		}
-		atomic.StoreUint64(&cache.head, start-1)
-		atomic.StoreUint64(&cache.tail, start)
 	}
+	atomic.StoreUint64(&cache.commitSeq, atomic.LoadUint64(&cache.seq))
+	if err := cache.attachShared(); err != nil {
+		closeShards(shards)
+		return nil, err
+	}
+	cache.prefetcher = cache.startPrefetcher()
+	cache.startFlusher()
+	cache.startRetention()
 
 	return cache, nil
 }
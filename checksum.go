@@ -0,0 +1,187 @@
+package archive
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math/bits"
+)
+
+// ChecksumAlgo selects the checksum stored in every slot header. It is
+// pinned in .cfg; caches created before the option existed use ChecksumIEEE,
+// and ConfigMigrate rewrites a cache with another algorithm.
+type ChecksumAlgo int
+
+const (
+	// ChecksumIEEE is a 4-byte CRC32 with the IEEE polynomial (default).
+	ChecksumIEEE ChecksumAlgo = iota
+	// ChecksumCRC32C is a 4-byte CRC32 with the Castagnoli polynomial,
+	// computed with CPU instructions on amd64 and arm64.
+	ChecksumCRC32C
+	// ChecksumXXHash64 is an 8-byte xxHash64, for large records.
+	ChecksumXXHash64
+	// ChecksumNone stores no checksum. Corruption and torn writes go
+	// undetected; use it only when the storage layer checks data itself.
+	ChecksumNone
+)
+
+var checksumNames = [...]string{"ieee", "crc32c", "xxhash64", "none"}
+
+// String returns the name persisted in .cfg.
+func (a ChecksumAlgo) String() string {
+	if a < 0 || int(a) >= len(checksumNames) {
+		return fmt.Sprintf("ChecksumAlgo(%d)", int(a))
+	}
+	return checksumNames[a]
+}
+
+// checksumName returns the name persisted for a ("" for the default).
+func checksumName(a ChecksumAlgo) string {
+	if a == ChecksumIEEE {
+		return ""
+	}
+	return a.String()
+}
+
+// lookupChecksum returns the algorithm persisted as name.
+func lookupChecksum(name string) (ChecksumAlgo, error) {
+	if name == "" {
+		return ChecksumIEEE, nil
+	}
+	for i, n := range checksumNames {
+		if n == name {
+			return ChecksumAlgo(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown checksum %q", name)
+}
+
+// size returns the width of the checksum in the slot header.
+func (a ChecksumAlgo) size() int {
+	switch a {
+	case ChecksumXXHash64:
+		return 8
+	case ChecksumNone:
+		return 0
+	}
+	return 4
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// sum returns the checksum of b.
+func (a ChecksumAlgo) sum(b []byte) uint64 {
+	switch a {
+	case ChecksumCRC32C:
+		return uint64(crc32.Checksum(b, castagnoli))
+	case ChecksumXXHash64:
+		return xxhash64(b)
+	case ChecksumNone:
+		return 0
+	}
+	return uint64(crc32.ChecksumIEEE(b))
+}
+
+// complement returns the bitwise complement of sum in the width of a, which
+// marks tombstones in the fixed-size layout.
+func (a ChecksumAlgo) complement(sum uint64) uint64 {
+	if a.size() == 4 {
+		return uint64(^uint32(sum))
+	}
+	return ^sum
+}
+
+// label names the checksum in corruption errors.
+func (a ChecksumAlgo) label() string {
+	if a == ChecksumXXHash64 {
+		return "xxHash64"
+	}
+	return "CRC"
+}
+
+// putSum stores sum at the start of buf in the width of a.
+func (a ChecksumAlgo) putSum(buf []byte, sum uint64) {
+	switch a.size() {
+	case 4:
+		binary.LittleEndian.PutUint32(buf, uint32(sum))
+	case 8:
+		binary.LittleEndian.PutUint64(buf, sum)
+	}
+}
+
+// storedSum reads the checksum stored at the start of buf.
+func (a ChecksumAlgo) storedSum(buf []byte) uint64 {
+	switch a.size() {
+	case 4:
+		return uint64(binary.LittleEndian.Uint32(buf))
+	case 8:
+		return binary.LittleEndian.Uint64(buf)
+	}
+	return 0
+}
+
+// xxHash64 with seed 0, as specified at
+// https://github.com/Cyan4973/xxHash/blob/dev/doc/xxhash_spec.md.
+
+const (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+func xxhash64(b []byte) uint64 {
+	n := len(b)
+	var h uint64
+	if n >= 32 {
+		p1, p2 := xxPrime1, xxPrime2 // variables, so the sums below may wrap
+		v1, v2, v3, v4 := p1+p2, p2, uint64(0), -p1
+		for ; len(b) >= 32; b = b[32:] {
+			v1 = xxRound(v1, binary.LittleEndian.Uint64(b))
+			v2 = xxRound(v2, binary.LittleEndian.Uint64(b[8:]))
+			v3 = xxRound(v3, binary.LittleEndian.Uint64(b[16:]))
+			v4 = xxRound(v4, binary.LittleEndian.Uint64(b[24:]))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) +
+			bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxMerge(h, v1)
+		h = xxMerge(h, v2)
+		h = xxMerge(h, v3)
+		h = xxMerge(h, v4)
+	} else {
+		h = xxPrime5
+	}
+	h += uint64(n)
+
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= xxRound(0, binary.LittleEndian.Uint64(b))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b)) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return h
+}
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	return bits.RotateLeft64(acc, 31) * xxPrime1
+}
+
+func xxMerge(acc, v uint64) uint64 {
+	acc ^= xxRound(0, v)
+	return acc*xxPrime1 + xxPrime4
+}
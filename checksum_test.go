@@ -0,0 +1,137 @@
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestXXHash64(t *testing.T) {
+	for in, want := range map[string]uint64{
+		"":    0xef46db3751d8e999,
+		"a":   0xd24ec4f1a98c6e5b,
+		"abc": 0x44bc2cf5ad770999,
+		"Nobody inspects the spammish repetition": 0xfbcea83c8a378bf1,
+	} {
+		if got := xxhash64([]byte(in)); got != want {
+			t.Fatalf("xxhash64(%q) = %x, want %x", in, got, want)
+		}
+	}
+}
+
+func TestChecksumAlgos(t *testing.T) {
+	widths := map[ChecksumAlgo]int{ChecksumIEEE: 4, ChecksumCRC32C: 4, ChecksumXXHash64: 8, ChecksumNone: 0}
+	for algo, width := range widths {
+		for _, variable := range []bool{false, true} {
+			name := algo.String()
+			if variable {
+				name += "/variable"
+			}
+			t.Run(name, func(t *testing.T) {
+				opts := DefaultOptions()
+				opts.MaxIDAlloc = 8
+				opts.VariableLength = variable
+				opts.Sequenced = variable // fixed caches keep the legacy header
+				opts.Checksum = algo
+				cache, base := newTestCacheWithOpts(t, 8, 4, opts)
+				if cache.layout.sumSize != width {
+					t.Fatalf("checksum is %d bytes, want %d", cache.layout.sumSize, width)
+				}
+				if !variable && algo != ChecksumNone && cache.layout.hdrSize != width {
+					t.Fatalf("fixed-size header is %d bytes, want %d", cache.layout.hdrSize, width)
+				}
+				for _, p := range []string{"r001", "r002", "r003"} {
+					if _, err := cache.WriteHead([]byte(p), false); err != nil {
+						t.Fatalf("WriteHead: %v", err)
+					}
+				}
+				if err := cache.Delete(2); err != nil {
+					t.Fatalf("Delete: %v", err)
+				}
+				if got, err := cache.Read(3); err != nil || string(got) != "r003" {
+					t.Fatalf("Read = %q, %v", got, err)
+				}
+				if _, err := cache.Read(2); !errors.Is(err, ErrDeleted) {
+					t.Fatalf("Read deleted = %v", err)
+				}
+				if _, err := cache.Read(5); err == nil {
+					t.Fatalf("slot that was never written read back")
+				}
+				opts = cache.options
+				cache.Close()
+
+				f, _ := os.OpenFile(shardPath(base, 1, 0), os.O_RDWR, 0)
+				f.WriteAt([]byte{'X'}, int64(cache.layout.hdrSize))
+				f.Close()
+
+				reopened, err := NewRingBufferCacheWithOptions(base, opts)
+				if err != nil {
+					t.Fatalf("reopen: %v", err)
+				}
+				defer reopened.Close()
+				if reopened.Head() != 3 {
+					t.Fatalf("head after reopen = %d, want 3", reopened.Head())
+				}
+				got, err := reopened.Read(1)
+				if algo == ChecksumNone {
+					if err != nil || string(got) != "X001" {
+						t.Fatalf("Read without checksum = %q, %v", got, err)
+					}
+				} else if !errors.Is(err, ErrCorrupted) {
+					t.Fatalf("corrupted payload read back: %q, %v", got, err)
+				}
+			})
+		}
+	}
+}
+
+func TestChecksumConfig(t *testing.T) {
+	cache, base := newTestCache(t, 8, 4)
+	cache.WriteHead([]byte("r001"), false)
+	opts := cache.options
+	cache.Close()
+
+	// caches from before the option keep IEEE
+	cfg, _ := os.ReadFile(base + ".cfg")
+	if strings.Contains(string(cfg), "checksum") {
+		t.Fatalf(".cfg of an IEEE cache names a checksum:\n%s", cfg)
+	}
+	opts.Checksum = ChecksumCRC32C
+	adopted, err := NewRingBufferCacheWithOptions(base, opts)
+	if err != nil {
+		t.Fatalf("adopt: %v", err)
+	}
+	if adopted.options.Checksum != ChecksumIEEE {
+		t.Fatalf("adopted checksum %v, want ieee", adopted.options.Checksum)
+	}
+	adopted.Close()
+
+	opts.ConfigPolicy = ConfigStrict
+	_, err = NewRingBufferCacheWithOptions(base, opts)
+	var mismatch *ConfigMismatchError
+	if !errors.As(err, &mismatch) || mismatch.Fields[0].Field != "Checksum" {
+		t.Fatalf("strict open with another checksum: %v", err)
+	}
+
+	opts.ConfigPolicy = ConfigMigrate
+	migrated, err := NewRingBufferCacheWithOptions(base, opts)
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if got, err := migrated.Read(1); err != nil || string(got) != "r001" {
+		t.Fatalf("Read after migrate = %q, %v", got, err)
+	}
+	migrated.Close()
+	cfg, _ = os.ReadFile(base + ".cfg")
+	if !strings.Contains(string(cfg), `"checksum": "crc32c"`) {
+		t.Fatalf(".cfg does not pin the checksum:\n%s", cfg)
+	}
+
+	os.WriteFile(base+".cfg", bytes.Replace(cfg, []byte(`"crc32c"`), []byte(`"md5"`), 1), 0o644)
+	opts.ConfigPolicy = ConfigAdopt
+	if _, err := NewRingBufferCacheWithOptions(base, opts); err == nil || !strings.Contains(err.Error(), "md5") {
+		t.Fatalf("unknown checksum in .cfg: %v", err)
+	}
+}
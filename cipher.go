@@ -0,0 +1,293 @@
+package archive
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// KeyProvider supplies the AES-256 keys of an encrypted cache. Keys are
+// identified by caller-chosen IDs; every record stores the ID of the key it
+// was sealed with, so rotating keys only changes CurrentKeyID and older
+// records stay readable as long as Key still returns their key.
+type KeyProvider interface {
+	// CurrentKeyID returns the ID of the key new records are sealed with.
+	CurrentKeyID() (uint32, error)
+	// Key returns the 32-byte key with the given ID.
+	Key(id uint32) ([]byte, error)
+}
+
+// StaticKeys is a KeyProvider backed by an in-memory key set.
+type StaticKeys struct {
+	Current uint32            // ID of the key used for new records
+	Keys    map[uint32][]byte // all known keys by ID
+}
+
+// CurrentKeyID implements KeyProvider.
+func (k StaticKeys) CurrentKeyID() (uint32, error) { return k.Current, nil }
+
+// Key implements KeyProvider.
+func (k StaticKeys) Key(id uint32) ([]byte, error) {
+	key, ok := k.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %d", id)
+	}
+	return key, nil
+}
+
+// Cipher encrypts record payloads at rest with AES-256-GCM. Set it as
+// CacheOptions.Cipher; the cache then needs VariableLength, since a sealed
+// record is longer than its payload.
+//
+// A sealed record holds the key ID (4 bytes), a generation (8 bytes) and the
+// ciphertext with its 16-byte tag. The nonce is the generation followed by
+// the low 32 bits of the record ID, and the full ID is authenticated as
+// additional data, so a record copied to another slot fails to open. The
+// generation comes from a per-cache counter that starts at a random value
+// and never repeats: it is reserved in blocks in <base>.gen before use, so a
+// crash skips values instead of reusing them. The slot checksum is kept and
+// still catches torn writes before decryption is attempted.
+//
+// .cfg records that the cache is encrypted and the current key ID, never a
+// key. Compression (Codec) is applied before encryption.
+type Cipher struct {
+	keys  KeyProvider
+	mu    sync.Mutex
+	aeads map[uint32]cipher.AEAD
+}
+
+// NewCipher returns an AES-256-GCM Cipher using keys from kp.
+func NewCipher(kp KeyProvider) *Cipher {
+	return &Cipher{keys: kp, aeads: make(map[uint32]cipher.AEAD)}
+}
+
+const (
+	cipherName     = "aes-256-gcm"
+	sealHeader     = 12 // key ID + generation
+	sealOverhead   = sealHeader + 16
+	genReserveSize = 1 << 16 // generations reserved per write of .gen
+)
+
+// cipherNameOf returns the name persisted for x ("" for no encryption).
+func cipherNameOf(x *Cipher) string {
+	if x == nil {
+		return ""
+	}
+	return cipherName
+}
+
+// aead returns the AEAD for key id, creating it on first use.
+func (x *Cipher) aead(id uint32) (cipher.AEAD, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if a, ok := x.aeads[id]; ok {
+		return a, nil
+	}
+	key, err := x.keys.Key(id)
+	if err != nil {
+		return nil, fmt.Errorf("key %d: %w", id, err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key %d: AES-256 needs 32 bytes, got %d", id, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	a, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	x.aeads[id] = a
+	return a, nil
+}
+
+// current returns the ID and AEAD of the key for new records.
+func (x *Cipher) current() (uint32, cipher.AEAD, error) {
+	id, err := x.keys.CurrentKeyID()
+	if err != nil {
+		return 0, nil, fmt.Errorf("current key: %w", err)
+	}
+	a, err := x.aead(id)
+	return id, a, err
+}
+
+func sealNonce(gen uint64, id int64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, gen)
+	binary.BigEndian.PutUint32(nonce[8:], uint32(id))
+	return nonce
+}
+
+func sealAD(id int64) []byte {
+	return binary.LittleEndian.AppendUint64(nil, uint64(id))
+}
+
+// sealedLen returns the stored length of a record of n bytes.
+func (c *RingBufferCache) sealedLen(n int) int {
+	if c.cipher == nil {
+		return n
+	}
+	return n + sealOverhead
+}
+
+// sealRecord encrypts data for storage at id. It returns data unchanged when
+// the cache is not encrypted.
+func (c *RingBufferCache) sealRecord(id int64, data []byte) ([]byte, error) {
+	if c.cipher == nil {
+		return data, nil
+	}
+	keyID, a, err := c.cipher.current()
+	if err != nil {
+		return nil, err
+	}
+	gen, err := c.gens.next()
+	if err != nil {
+		return nil, fmt.Errorf("reserve generation: %w", err)
+	}
+	out := make([]byte, sealHeader, sealOverhead+len(data))
+	binary.LittleEndian.PutUint32(out, keyID)
+	binary.LittleEndian.PutUint64(out[4:], gen)
+	return a.Seal(out, sealNonce(gen, id), data, sealAD(id)), nil
+}
+
+// sealRecords seals a batch of records written from id on.
+func (c *RingBufferCache) sealRecords(id int64, payloads [][]byte) ([][]byte, error) {
+	if c.cipher == nil {
+		return payloads, nil
+	}
+	out := make([][]byte, len(payloads))
+	for i, p := range payloads {
+		s, err := c.sealRecord(id, p)
+		if err != nil {
+			return nil, fmt.Errorf("payload %d: %w", i, err)
+		}
+		out[i] = s
+		id = c.advanceID(id, c.slotsFor(len(s)))
+	}
+	return out, nil
+}
+
+// openRecord decrypts the sealed record stored at id.
+func (c *RingBufferCache) openRecord(id int64, stored []byte) ([]byte, error) {
+	if len(stored) < sealOverhead {
+		return nil, fmt.Errorf("%w: sealed record is %d bytes", ErrCorrupted, len(stored))
+	}
+	keyID := binary.LittleEndian.Uint32(stored)
+	gen := binary.LittleEndian.Uint64(stored[4:])
+	a, err := c.cipher.aead(keyID)
+	if err != nil {
+		return nil, err
+	}
+	out, err := a.Open(nil, sealNonce(gen, id), stored[sealHeader:], sealAD(id))
+	if err != nil {
+		return nil, fmt.Errorf("%w: authentication failed: %w", ErrCorrupted, err)
+	}
+	return out, nil
+}
+
+// transformed reports whether stored bytes differ from payloads.
+func (c *RingBufferCache) transformed() bool { return c.codec != nil || c.cipher != nil }
+
+// decodeStored appends the payload of the record stored at id to dst,
+// decrypting and decompressing as configured. Only called when transformed.
+func (c *RingBufferCache) decodeStored(dst []byte, id int64, stored []byte) ([]byte, error) {
+	if c.cipher != nil {
+		var err error
+		if stored, err = c.openRecord(id, stored); err != nil {
+			return nil, err
+		}
+		if c.codec == nil {
+			if dst == nil {
+				return stored, nil
+			}
+			return append(dst, stored...), nil
+		}
+	}
+	return decodeRecord(dst, stored)
+}
+
+// storedPayloadLen returns the payload length of a decrypted record.
+func (c *RingBufferCache) storedPayloadLen(plain []byte) (int, error) {
+	if c.codec == nil {
+		return len(plain), nil
+	}
+	_, n, _, err := recordFrame(plain)
+	return n, err
+}
+
+// generations hands out the nonce generations of an encrypted cache.
+type generations struct {
+	mu    sync.Mutex
+	path  string
+	cur   uint64 // last generation handed out
+	limit uint64 // generations up to limit are reserved in the file
+}
+
+func genPath(base string) string { return base + ".gen" }
+
+// readGeneration returns the reservation recorded at path (0 if none).
+func readGeneration(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(data) != 8 {
+		return 0, fmt.Errorf("%w: %s is %d bytes", ErrCorrupted, path, len(data))
+	}
+	return binary.LittleEndian.Uint64(data), nil
+}
+
+// loadGenerations resumes after the last reservation made at path. A new
+// cache starts at a random generation, so caches sharing a key use disjoint
+// nonces.
+func loadGenerations(path string) (*generations, error) {
+	v, err := readGeneration(path)
+	if err != nil {
+		return nil, fmt.Errorf("load generations: %w", err)
+	}
+	if v == 0 {
+		var b [8]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return nil, err
+		}
+		v = binary.LittleEndian.Uint64(b[:]) >> 1 // leaves room to count up
+	}
+	return &generations{path: path, cur: v, limit: v}, nil
+}
+
+// seed makes g continue after v, e.g. the reservation of a cache being
+// migrated that was sealed with the same keys, and records the position at
+// g.path: a copy that seals nothing must still carry it over.
+func (g *generations) seed(v uint64) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if v > g.cur {
+		g.cur, g.limit = v, v
+	}
+	return writeFileAtomic(g.path, binary.LittleEndian.AppendUint64(nil, g.limit))
+}
+
+// next returns a generation that was never returned before, reserving a new
+// block on disk when the current one is used up.
+func (g *generations) next() (uint64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.cur == g.limit {
+		limit := g.limit + genReserveSize
+		if err := writeFileAtomic(g.path, binary.LittleEndian.AppendUint64(nil, limit)); err != nil {
+			return 0, err
+		}
+		g.limit = limit
+	}
+	g.cur++
+	return g.cur, nil
+}
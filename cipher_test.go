@@ -0,0 +1,232 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var (
+	testKey1 = bytes.Repeat([]byte{'k'}, 32)
+	testKey2 = bytes.Repeat([]byte{'q'}, 32)
+)
+
+// cipherOptions returns codecOptions(codec) encrypted with testKey1.
+func cipherOptions(codec Codec) CacheOptions {
+	opts := codecOptions(codec)
+	opts.Cipher = NewCipher(StaticKeys{Current: 1, Keys: map[uint32][]byte{1: testKey1}})
+	return opts
+}
+
+func TestCipherCache(t *testing.T) {
+	for _, codec := range []Codec{nil, CodecLZ4} {
+		t.Run(codecName(codec), func(t *testing.T) {
+			cache, base := newTestCacheWithOpts(t, 64, 64, cipherOptions(codec))
+			secret := []byte("attack at dawn")
+			long := []byte(strings.Repeat("confidential ", 20)) // several slots
+
+			id, err := cache.WriteHead(secret, false)
+			if err != nil {
+				t.Fatalf("WriteHead: %v", err)
+			}
+			lid, _ := cache.WriteHead(long, false)
+			if _, err := cache.WriteHeadBatch([][]byte{secret, long}, false); err != nil {
+				t.Fatalf("WriteHeadBatch: %v", err)
+			}
+
+			if got, err := cache.Read(id); err != nil || !bytes.Equal(got, secret) {
+				t.Fatalf("Read = %q, %v", got, err)
+			}
+			if got, err := cache.Read(lid); err != nil || !bytes.Equal(got, long) {
+				t.Fatalf("Read long = %d bytes, %v", len(got), err)
+			}
+			dst := make([]byte, len(long))
+			if n, err := cache.ReadInto(lid, dst); err != nil || !bytes.Equal(dst[:n], long) {
+				t.Fatalf("ReadInto = %d, %v", n, err)
+			}
+			if err := cache.View(id, func(p []byte) error {
+				if !bytes.Equal(p, secret) {
+					return fmt.Errorf("view got %q", p)
+				}
+				return nil
+			}); err != nil {
+				t.Fatalf("View: %v", err)
+			}
+			var n int
+			for _, p := range cache.All() {
+				if want := [][]byte{secret, long}[n%2]; !bytes.Equal(p, want) {
+					t.Fatalf("All record %d = %q", n, p)
+				}
+				n++
+			}
+			if n != 4 {
+				t.Fatalf("All yielded %d records", n)
+			}
+			cache.Close()
+
+			data, _ := os.ReadFile(shardPath(base, 1, 0))
+			if bytes.Contains(data, []byte("attack")) || bytes.Contains(data, []byte("confidential")) {
+				t.Fatalf("plaintext in the shard file")
+			}
+			cfg, _ := os.ReadFile(base + ".cfg")
+			if !strings.Contains(string(cfg), `"cipher": "aes-256-gcm"`) || !strings.Contains(string(cfg), `"key_id": 1`) {
+				t.Fatalf(".cfg does not record the cipher:\n%s", cfg)
+			}
+			if bytes.Contains(cfg, testKey1[:8]) {
+				t.Fatalf(".cfg contains the key")
+			}
+		})
+	}
+}
+
+func TestCipherAuthentication(t *testing.T) {
+	cache, base := newTestCacheWithOpts(t, 64, 64, cipherOptions(nil))
+	sealed, err := cache.sealRecord(5, []byte("payload"))
+	if err != nil {
+		t.Fatalf("sealRecord: %v", err)
+	}
+	if _, err := cache.openRecord(6, sealed); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("record moved to another ID opened: %v", err)
+	}
+	sealed[len(sealed)-1] ^= 1
+	if _, err := cache.openRecord(5, sealed); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("tampered record opened: %v", err)
+	}
+	again, _ := cache.sealRecord(5, []byte("payload"))
+	if bytes.Equal(again[4:sealHeader], sealed[4:sealHeader]) {
+		t.Fatalf("generation reused for the same ID")
+	}
+
+	cache.WriteHead([]byte("payload"), false)
+	opts := cache.options
+	cache.Close()
+
+	// same key ID, different key
+	opts.Cipher = NewCipher(StaticKeys{Current: 1, Keys: map[uint32][]byte{1: testKey2}})
+	wrong, err := NewRingBufferCacheWithOptions(base, opts)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer wrong.Close()
+	if _, err := wrong.Read(1); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("Read with the wrong key: %v", err)
+	}
+}
+
+func TestCipherKeyRotation(t *testing.T) {
+	cache, base := newTestCacheWithOpts(t, 64, 64, cipherOptions(nil))
+	cache.WriteHead([]byte("old key"), false)
+	opts := cache.options
+	cache.Close()
+
+	opts.Cipher = NewCipher(StaticKeys{Current: 2, Keys: map[uint32][]byte{1: testKey1, 2: testKey2}})
+	rotated, err := NewRingBufferCacheWithOptions(base, opts)
+	if err != nil {
+		t.Fatalf("reopen with a new key: %v", err)
+	}
+	id, _ := rotated.WriteHead([]byte("new key"), false)
+	if got, err := rotated.Read(1); err != nil || string(got) != "old key" {
+		t.Fatalf("Read old record = %q, %v", got, err)
+	}
+	if got, err := rotated.Read(id); err != nil || string(got) != "new key" {
+		t.Fatalf("Read new record = %q, %v", got, err)
+	}
+	rotated.Close()
+	cfg, _ := os.ReadFile(base + ".cfg")
+	if !strings.Contains(string(cfg), `"key_id": 2`) {
+		t.Fatalf(".cfg does not record the rotation:\n%s", cfg)
+	}
+
+	// retiring key 1 leaves its records unreadable
+	opts.Cipher = NewCipher(StaticKeys{Current: 2, Keys: map[uint32][]byte{2: testKey2}})
+	retired, err := NewRingBufferCacheWithOptions(base, opts)
+	if err != nil {
+		t.Fatalf("reopen without key 1: %v", err)
+	}
+	defer retired.Close()
+	if _, err := retired.Read(1); err == nil || !strings.Contains(err.Error(), "unknown key ID 1") {
+		t.Fatalf("Read with a retired key: %v", err)
+	}
+	if got, err := retired.Read(id); err != nil || string(got) != "new key" {
+		t.Fatalf("Read new record = %q, %v", got, err)
+	}
+}
+
+func TestCipherGenerations(t *testing.T) {
+	cache, base := newTestCacheWithOpts(t, 64, 64, cipherOptions(nil))
+	cache.WriteHead([]byte("one"), false)
+	used := cache.gens.cur
+	opts := cache.options
+	crash(cache)
+
+	data, err := os.ReadFile(genPath(base))
+	if err != nil || len(data) != 8 {
+		t.Fatalf(".gen = %x, %v", data, err)
+	}
+	if limit := binary.LittleEndian.Uint64(data); limit < used {
+		t.Fatalf(".gen reserves up to %d, generation %d already used", limit, used)
+	}
+
+	reopened, err := NewRingBufferCacheWithOptions(base, opts)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	id, _ := reopened.WriteHead([]byte("two"), false)
+	if reopened.gens.cur <= used {
+		t.Fatalf("generation %d reused after a crash (last was %d)", reopened.gens.cur, used)
+	}
+	if got, err := reopened.Read(id); err != nil || string(got) != "two" {
+		t.Fatalf("Read = %q, %v", got, err)
+	}
+}
+
+func TestCipherConfig(t *testing.T) {
+	cache, base := newTestCacheWithOpts(t, 64, 64, cipherOptions(nil))
+	cache.WriteHead([]byte("secret"), false)
+	opts := cache.options
+	cache.Close()
+
+	plain := opts
+	plain.Cipher = nil
+	plain.ConfigPolicy = ConfigAdopt
+	if _, err := NewRingBufferCacheWithOptions(base, plain); err == nil || !strings.Contains(err.Error(), "encrypted") {
+		t.Fatalf("reopen without Cipher: %v", err)
+	}
+
+	fixed := DefaultOptions()
+	fixed.RecordSize = 8
+	fixed.MaxIDAlloc = 8
+	fixed.Cipher = opts.Cipher
+	if _, err := NewRingBufferCacheWithOptions(filepath.Join(t.TempDir(), "cache.data"), fixed); err == nil {
+		t.Fatalf("Cipher without VariableLength accepted")
+	}
+
+	// encrypt a plaintext cache in place
+	plainCache, plainBase := newTestCacheWithOpts(t, 64, 64, codecOptions(nil))
+	plainCache.WriteHead([]byte("migrate me"), false)
+	popts := plainCache.options
+	plainCache.Close()
+	popts.Cipher = opts.Cipher
+	popts.ConfigPolicy = ConfigMigrate
+	enc, err := NewRingBufferCacheWithOptions(plainBase, popts)
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if got, err := enc.Read(1); err != nil || string(got) != "migrate me" {
+		t.Fatalf("Read after migrate = %q, %v", got, err)
+	}
+	enc.Close()
+	data, _ := os.ReadFile(shardPath(plainBase, 1, 0))
+	if bytes.Contains(data, []byte("migrate me")) {
+		t.Fatalf("plaintext left after migration")
+	}
+	if _, err := os.Stat(genPath(plainBase)); err != nil {
+		t.Fatalf(".gen not moved into place: %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCloseRejectsOperations(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Sequenced = true
+	cache, base := newTestCacheWithOpts(t, 10, 4, opts)
+	cache.WriteHead([]byte("abcd"), false)
+	cur, _ := cache.Cursor("app")
+
+	if err := cache.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if err := cache.Close(); err != nil {
+		t.Fatalf("second close should be a no-op, got %v", err)
+	}
+	if !cache.Closed() {
+		t.Fatalf("Closed() = false after Close")
+	}
+
+	checks := map[string]error{
+		"Write":  cache.Write(1, []byte("abcd"), false),
+		"Delete": cache.Delete(1),
+		"Flush":  cache.Flush(),
+	}
+	_, checks["Read"] = cache.Read(1)
+	_, checks["WriteHead"] = cache.WriteHead([]byte("abcd"), false)
+	_, checks["BulkRead"] = cache.BulkRead(1, 1)
+	_, checks["ReadSeq"] = cache.ReadSeq(1)
+	_, checks["Subscribe"] = cache.Subscribe(context.Background(), 0)
+	_, checks["Cursor.Next"] = cur.Next()
+	for op, err := range checks {
+		if !errors.Is(err, ErrClosed) {
+			t.Errorf("%s after Close: got %v, want ErrClosed", op, err)
+		}
+	}
+
+	reopened := reopenTestCache(t, base, 4)
+	defer reopened.Close()
+	if !reopened.Recovery().Clean || reopened.Head() != 1 {
+		t.Fatalf("final head not persisted: %+v", reopened.Recovery())
+	}
+}
+
+func TestCloseDrainsConcurrentOperations(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "cache.data")
+	opts := DefaultOptions()
+	opts.RecordSize = 8
+	opts.MaxIDAlloc = 256
+	opts.ShardCount = 4
+	opts.UseMmap = true
+	opts.PrefetchSize = 4
+	cache, err := NewRingBufferCacheWithOptions(base, opts)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub, err := cache.Subscribe(ctx, 0)
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				var err error
+				if g%2 == 0 {
+					_, err = cache.WriteHead([]byte("payload!"), false)
+				} else {
+					_, err = cache.Read(int64(i%256) + 1)
+				}
+				if errors.Is(err, ErrClosed) {
+					return
+				}
+			}
+		}(g)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if err := cache.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	wg.Wait()
+
+	for range sub {
+		// drain until the subscription ends
+	}
+}
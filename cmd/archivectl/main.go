@@ -0,0 +1,78 @@
+// Command archivectl runs maintenance tasks on go-cache-archive files.
+//
+// Usage:
+//
+//	archivectl reshard [-quiet] <base> <count>
+//
+// reshard redistributes the records of the cache at <base> over <count>
+// shard files (see archive.Reshard). It holds the writer lock while it runs;
+// an interrupted run continues from its last checkpoint when started again,
+// unless the cache was opened for writing in between.
+// Encrypted caches need a key provider and must be resharded from Go.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	archive "github.com/luhtfiimanal/go-cache-archive"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch os.Args[1] {
+	case "reshard":
+		err = reshard(os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		usage()
+	default:
+		fmt.Fprintf(os.Stderr, "archivectl: unknown command %q\n", os.Args[1])
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "archivectl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: archivectl reshard [-quiet] <base> <count>")
+	os.Exit(2)
+}
+
+func reshard(args []string) error {
+	fs := flag.NewFlagSet("reshard", flag.ExitOnError)
+	quiet := fs.Bool("quiet", false, "do not report progress")
+	fs.Usage = usage
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		usage()
+	}
+	base := fs.Arg(0)
+	count, err := strconv.Atoi(fs.Arg(1))
+	if err != nil || count <= 0 {
+		return fmt.Errorf("invalid shard count %q", fs.Arg(1))
+	}
+
+	opts := archive.ReshardOptions{Cache: archive.DefaultOptions()}
+	opts.Cache.PrefetchSize = 0
+	if !*quiet {
+		opts.Progress = func(p archive.ReshardProgress) {
+			pct := 100.0
+			if p.Total > 0 {
+				pct = float64(p.Copied) * 100 / float64(p.Total)
+			}
+			fmt.Fprintf(os.Stderr, "\rcopied %d/%d slots (%.1f%%)", p.Copied, p.Total, pct)
+		}
+	}
+	err = archive.Reshard(base, count, opts)
+	if !*quiet {
+		fmt.Fprintln(os.Stderr)
+	}
+	return err
+}
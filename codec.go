@@ -0,0 +1,190 @@
+package archive
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// Codec compresses record payloads. Set CacheOptions.Codec to store every
+// record compressed; the codec's name is pinned in .cfg.
+//
+// A compressed record starts with a small frame in its first slot: the
+// codec ID (one byte) and the original length (uvarint), followed by the
+// compressed bytes. A payload that does not shrink is stored with codec ID 0
+// instead, so a record never takes more slots than it would uncompressed
+// (plus the frame). Records are decoded with the codec named by their own
+// ID, so every codec that wrote to a cache must stay registered.
+type Codec interface {
+	// ID identifies the codec in each record. 0 is reserved for payloads
+	// stored as-is; IDs of custom codecs should start at 128.
+	ID() byte
+	// Name identifies the codec in .cfg.
+	Name() string
+	// Encode appends the compressed form of src to dst.
+	Encode(dst, src []byte) []byte
+	// Decode appends the n bytes decompressed from src to dst.
+	Decode(dst, src []byte, n int) ([]byte, error)
+}
+
+var (
+	// CodecNone stores payloads as-is, exactly like a nil Codec.
+	CodecNone Codec = noneCodec{}
+	// CodecLZ4 is a pure Go LZ4 block codec: fast, with a moderate ratio.
+	CodecLZ4 Codec = lz4Codec{}
+)
+
+var (
+	codecMu     sync.RWMutex
+	codecByID   = map[byte]Codec{}
+	codecByName = map[string]Codec{}
+)
+
+func init() {
+	RegisterCodec(CodecLZ4)
+}
+
+// RegisterCodec makes c available to caches whose .cfg or records name it.
+// Built-in codecs are registered already. It panics when the ID or name is
+// taken or the ID is 0.
+func RegisterCodec(c Codec) {
+	codecMu.Lock()
+	defer codecMu.Unlock()
+	if c.ID() == 0 {
+		panic("archive: codec ID 0 is reserved")
+	}
+	if _, dup := codecByID[c.ID()]; dup {
+		panic(fmt.Sprintf("archive: codec ID %d registered twice", c.ID()))
+	}
+	if _, dup := codecByName[c.Name()]; dup {
+		panic(fmt.Sprintf("archive: codec %q registered twice", c.Name()))
+	}
+	codecByID[c.ID()] = c
+	codecByName[c.Name()] = c
+}
+
+// codecName returns the name persisted for c ("" for no compression).
+func codecName(c Codec) string {
+	if c == nil || c.ID() == 0 {
+		return ""
+	}
+	return c.Name()
+}
+
+// lookupCodec returns the registered codec called name.
+func lookupCodec(name string) (Codec, error) {
+	if name == "" {
+		return nil, nil
+	}
+	codecMu.RLock()
+	defer codecMu.RUnlock()
+	c, ok := codecByName[name]
+	if !ok {
+		return nil, fmt.Errorf("codec %q is not registered", name)
+	}
+	return c, nil
+}
+
+// encodeRecord frames payload for storage. It returns payload unchanged when
+// the cache does not compress.
+func (c *RingBufferCache) encodeRecord(payload []byte) ([]byte, error) {
+	if c.codec == nil {
+		return payload, nil
+	}
+	if len(payload) > infoLenMask {
+		return nil, fmt.Errorf("%w: %d bytes exceeds the maximum of %d", ErrPayloadSize, len(payload), infoLenMask)
+	}
+	out := make([]byte, 1, 1+binary.MaxVarintLen32+len(payload))
+	out[0] = c.codec.ID()
+	out = binary.AppendUvarint(out, uint64(len(payload)))
+	hdr := len(out)
+	out = c.codec.Encode(out, payload)
+	if len(out)-hdr >= len(payload) {
+		// incompressible: store as-is
+		out[0] = 0
+		out = append(out[:hdr], payload...)
+	}
+	return out, nil
+}
+
+// encodeRecords frames every payload of a batch.
+func (c *RingBufferCache) encodeRecords(payloads [][]byte) ([][]byte, error) {
+	if c.codec == nil {
+		return payloads, nil
+	}
+	out := make([][]byte, len(payloads))
+	for i, p := range payloads {
+		e, err := c.encodeRecord(p)
+		if err != nil {
+			return nil, fmt.Errorf("payload %d: %w", i, err)
+		}
+		out[i] = e
+	}
+	return out, nil
+}
+
+// recordFrame parses the frame of a stored record and returns its codec,
+// original length and compressed bytes. The codec is nil for ID 0.
+func recordFrame(stored []byte) (Codec, int, []byte, error) {
+	if len(stored) == 0 {
+		return nil, 0, nil, fmt.Errorf("%w: empty record frame", ErrCorrupted)
+	}
+	n, k := binary.Uvarint(stored[1:])
+	if k <= 0 || n > infoLenMask {
+		return nil, 0, nil, fmt.Errorf("%w: bad record frame", ErrCorrupted)
+	}
+	body := stored[1+k:]
+	if stored[0] == 0 {
+		if int(n) != len(body) {
+			return nil, 0, nil, fmt.Errorf("%w: stored record is %d bytes, frame says %d", ErrCorrupted, len(body), n)
+		}
+		return nil, int(n), body, nil
+	}
+	codecMu.RLock()
+	codec, ok := codecByID[stored[0]]
+	codecMu.RUnlock()
+	if !ok {
+		return nil, 0, nil, fmt.Errorf("%w: unknown codec ID %d", ErrCorrupted, stored[0])
+	}
+	return codec, int(n), body, nil
+}
+
+// decodeRecord appends the payload of the framed record stored to dst.
+func decodeRecord(dst, stored []byte) ([]byte, error) {
+	codec, n, body, err := recordFrame(stored)
+	if err != nil {
+		return nil, err
+	}
+	if codec == nil {
+		return append(dst, body...), nil
+	}
+	start := len(dst)
+	out, err := codec.Decode(dst, body, n)
+	if err == nil && len(out)-start != n {
+		err = fmt.Errorf("decoded %d bytes, frame says %d", len(out)-start, n)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrCorrupted, codec.Name(), err)
+	}
+	return out, nil
+}
+
+type noneCodec struct{}
+
+func (noneCodec) ID() byte                      { return 0 }
+func (noneCodec) Name() string                  { return "none" }
+func (noneCodec) Encode(dst, src []byte) []byte { return append(dst, src...) }
+func (noneCodec) Decode(dst, src []byte, n int) ([]byte, error) {
+	return append(dst, src...), nil
+}
+
+type lz4Codec struct{}
+
+func (lz4Codec) ID() byte     { return 1 }
+func (lz4Codec) Name() string { return "lz4" }
+
+func (lz4Codec) Encode(dst, src []byte) []byte { return lz4Compress(dst, src) }
+
+func (lz4Codec) Decode(dst, src []byte, n int) ([]byte, error) {
+	return lz4Decompress(dst, src, n)
+}
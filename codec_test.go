@@ -0,0 +1,195 @@
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLZ4RoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 70000)
+	rng.Read(random)
+	inputs := map[string][]byte{
+		"empty":   {},
+		"short":   []byte("abc"),
+		"run":     bytes.Repeat([]byte{'a'}, 1000),
+		"pattern": bytes.Repeat([]byte("abcabd"), 5000),
+		"random":  random,
+		"json":    []byte(strings.Repeat(`{"sensor":"t-17","value":21.5,"unit":"C"},`, 300)),
+		"mixed":   append(bytes.Repeat([]byte("xyz0123456789"), 6000), random[:500]...),
+	}
+	for name, in := range inputs {
+		enc := lz4Compress([]byte{0xEE}, in)
+		out, err := lz4Decompress([]byte{0xDD}, enc[1:], len(in))
+		if err != nil || !bytes.Equal(out[1:], in) || out[0] != 0xDD {
+			t.Fatalf("%s: round trip failed (%v)", name, err)
+		}
+		if name == "json" && len(enc) > len(in)/4 {
+			t.Fatalf("json compressed to %d of %d bytes", len(enc), len(in))
+		}
+		if len(enc) > 1 {
+			if _, err := lz4Decompress(nil, enc[1:len(enc)-1], len(in)); err == nil {
+				t.Fatalf("%s: truncated block decoded", name)
+			}
+		}
+	}
+
+	// a block built by hand from the format description
+	block := []byte{0x3C, 'a', 'b', 'c', 0x03, 0x00, 0x50, 'b', 'c', 'a', 'b', 'c'}
+	want := []byte(strings.Repeat("abc", 8))
+	if got, err := lz4Decompress(nil, block, len(want)); err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("reference block = %q, %v", got, err)
+	}
+	if got := lz4Compress(nil, want); !bytes.Equal(got, block) {
+		t.Fatalf("encoded %x, want %x", got, block)
+	}
+	if _, err := lz4Decompress(nil, []byte{0x0F, 0x05, 0x00}, 8); err == nil {
+		t.Fatalf("match before the start of the block decoded")
+	}
+}
+
+// codecOptions returns the options of a 64-slot VariableLength test cache
+// that compresses with codec.
+func codecOptions(codec Codec) CacheOptions {
+	opts := DefaultOptions()
+	opts.MaxIDAlloc = 64
+	opts.VariableLength = true
+	opts.Codec = codec
+	return opts
+}
+
+func TestCodecCache(t *testing.T) {
+	cache, base := newTestCacheWithOpts(t, 64, 64, codecOptions(CodecLZ4))
+	doc := []byte(strings.Repeat(`{"sensor":"t-17","value":21.5},`, 20)) // 620 bytes, 10 raw slots
+	random := make([]byte, 100)
+	rand.New(rand.NewSource(2)).Read(random)
+
+	id, err := cache.WriteHead(doc, false)
+	if err != nil {
+		t.Fatalf("WriteHead: %v", err)
+	}
+	if used := cache.Head() - id + 1; used > 2 {
+		t.Fatalf("compressed record uses %d slots", used)
+	}
+	rid, _ := cache.WriteHead(random, false)
+	if used := cache.Head() - rid + 1; used != 2 {
+		t.Fatalf("incompressible record uses %d slots, want 2", used)
+	}
+	if _, err := cache.WriteHeadBatch([][]byte{doc, []byte("tiny")}, false); err != nil {
+		t.Fatalf("WriteHeadBatch: %v", err)
+	}
+
+	if got, err := cache.Read(id); err != nil || !bytes.Equal(got, doc) {
+		t.Fatalf("Read = %d bytes, %v", len(got), err)
+	}
+	if got, err := cache.Read(rid); err != nil || !bytes.Equal(got, random) {
+		t.Fatalf("Read incompressible = %v", err)
+	}
+	dst := make([]byte, len(doc))
+	if n, err := cache.ReadInto(id, dst); err != nil || n != len(doc) || !bytes.Equal(dst, doc) {
+		t.Fatalf("ReadInto = %d, %v", n, err)
+	}
+	if n, err := cache.ReadInto(id, dst[:10]); !errors.Is(err, io.ErrShortBuffer) || n != len(doc) {
+		t.Fatalf("short ReadInto = %d, %v", n, err)
+	}
+	if err := cache.View(id, func(p []byte) error {
+		if !bytes.Equal(p, doc) {
+			return fmt.Errorf("view got %d bytes", len(p))
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("View: %v", err)
+	}
+	var payloads []string
+	for _, p := range cache.All() {
+		payloads = append(payloads, string(p))
+	}
+	if len(payloads) != 4 || payloads[0] != string(doc) || payloads[3] != "tiny" {
+		t.Fatalf("All yielded %d records", len(payloads))
+	}
+	if recs, err := cache.BulkRead(id, 4); err != nil || len(recs) != 4 || string(recs[3]) != "tiny" {
+		t.Fatalf("BulkRead = %d records, %v", len(recs), err)
+	}
+	cache.Close()
+
+	cfg, _ := os.ReadFile(base + ".cfg")
+	if !strings.Contains(string(cfg), `"codec": "lz4"`) {
+		t.Fatalf(".cfg does not pin the codec:\n%s", cfg)
+	}
+}
+
+func TestCodecPinnedInConfig(t *testing.T) {
+	cache, base := newTestCacheWithOpts(t, 64, 64, codecOptions(CodecLZ4))
+	doc := []byte(strings.Repeat("abcdefgh", 40))
+	cache.WriteHead(doc, false)
+	opts := cache.options
+	cache.Close()
+
+	opts.Codec = nil
+	opts.ConfigPolicy = ConfigStrict
+	_, err := NewRingBufferCacheWithOptions(base, opts)
+	var mismatch *ConfigMismatchError
+	if !errors.As(err, &mismatch) || mismatch.Fields[0].Field != "Codec" {
+		t.Fatalf("reopen without codec: %v", err)
+	}
+
+	opts.ConfigPolicy = ConfigAdopt
+	adopted, err := NewRingBufferCacheWithOptions(base, opts)
+	if err != nil {
+		t.Fatalf("adopt: %v", err)
+	}
+	if got, err := adopted.Read(1); err != nil || !bytes.Equal(got, doc) {
+		t.Fatalf("Read after adopt: %v", err)
+	}
+	adopted.Close()
+
+	// migrate the records out of the compressed layout
+	opts.ConfigPolicy = ConfigMigrate
+	plain, err := NewRingBufferCacheWithOptions(base, opts)
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if got, err := plain.Read(1); err != nil || !bytes.Equal(got, doc) || plain.Head() != 5 {
+		t.Fatalf("Read after migrate: head %d, %v", plain.Head(), err)
+	}
+	plain.Close()
+
+	// CodecNone needs no VariableLength
+	opts = DefaultOptions()
+	opts.MaxIDAlloc = 8
+	opts.Codec = CodecNone
+	fixed, _ := newTestCacheWithOpts(t, 8, 8, opts)
+	fixed.Close()
+}
+
+func TestCodecErrors(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxIDAlloc = 8
+	opts.ShardCount = 1
+	opts.Codec = CodecLZ4
+	if _, err := NewRingBufferCacheWithOptions(filepath.Join(t.TempDir(), "cache.data"), opts); err == nil {
+		t.Fatalf("Codec without VariableLength accepted")
+	}
+
+	cache, base := newTestCacheWithOpts(t, 64, 64, codecOptions(CodecLZ4))
+	cache.Close()
+	cfg, _ := os.ReadFile(base + ".cfg")
+	os.WriteFile(base+".cfg", bytes.Replace(cfg, []byte(`"lz4"`), []byte(`"brotli"`), 1), 0o644)
+	if _, err := NewRingBufferCacheWithOptions(base, cache.options); err == nil || !strings.Contains(err.Error(), "brotli") {
+		t.Fatalf("unknown codec in .cfg: %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("duplicate codec ID registered")
+		}
+	}()
+	RegisterCodec(lz4Codec{})
+}
@@ -0,0 +1,30 @@
+//go:build zstd
+
+package archive
+
+import "github.com/klauspost/compress/zstd"
+
+// CodecZstd compresses with zstd: slower than CodecLZ4 with a better ratio.
+// It is only available in builds with the zstd tag.
+var CodecZstd Codec = zstdCodec{}
+
+var (
+	zstdEnc, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	zstdDec, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+)
+
+func init() {
+	RegisterCodec(CodecZstd)
+}
+
+type zstdCodec struct{}
+
+func (zstdCodec) ID() byte     { return 2 }
+func (zstdCodec) Name() string { return "zstd" }
+
+// EncodeAll and DecodeAll are safe for concurrent use.
+func (zstdCodec) Encode(dst, src []byte) []byte { return zstdEnc.EncodeAll(src, dst) }
+
+func (zstdCodec) Decode(dst, src []byte, n int) ([]byte, error) {
+	return zstdDec.DecodeAll(src, dst)
+}
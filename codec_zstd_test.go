@@ -0,0 +1,25 @@
+//go:build zstd
+
+package archive
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCodecZstd(t *testing.T) {
+	cache, _ := newTestCacheWithOpts(t, 64, 64, codecOptions(CodecZstd))
+	defer cache.Close()
+	doc := []byte(strings.Repeat(`{"sensor":"t-17","value":21.5},`, 20))
+	id, err := cache.WriteHead(doc, false)
+	if err != nil {
+		t.Fatalf("WriteHead: %v", err)
+	}
+	if used := cache.Head() - id + 1; used != 1 {
+		t.Fatalf("compressed record uses %d slots", used)
+	}
+	if got, err := cache.Read(id); err != nil || !bytes.Equal(got, doc) {
+		t.Fatalf("Read = %d bytes, %v", len(got), err)
+	}
+}
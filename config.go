@@ -1,64 +1,193 @@
 package archive
 
 import (
-    "encoding/json"
-    "fmt"
-    "os"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ConfigPolicy decides what NewRingBufferCacheWithOptions does when the
+// supplied options disagree with the layout persisted in the .cfg file.
+type ConfigPolicy int
+
+const (
+	// ConfigAdopt silently replaces the supplied options with the persisted
+	// ones (the historical behaviour).
+	ConfigAdopt ConfigPolicy = iota
+	// ConfigStrict fails with a *ConfigMismatchError.
+	ConfigStrict
+	// ConfigMigrate rebuilds the cache in the requested layout, re-encoding
+	// every record at its own ID, and then opens it. It fails and leaves the
+	// cache as it was when a record no longer fits at its ID; use Resize to
+	// narrow the ID range.
+	ConfigMigrate
 )
 
 // persistedConfig captures the subset of CacheOptions that affects file layout.
 type persistedConfig struct {
-    RecordSize  int   `json:"record_size"`
-    MinIDAlloc  int64 `json:"min_id_alloc"`
-    MaxIDAlloc  int64 `json:"max_id_alloc"`
-    ShardCount  int   `json:"shard_count"`
+	RecordSize     int     `json:"record_size"`
+	MinIDAlloc     int64   `json:"min_id_alloc"`
+	MaxIDAlloc     int64   `json:"max_id_alloc"`
+	ShardCount     int     `json:"shard_count"`
+	VariableLength bool    `json:"variable_length"`
+	Sequenced      bool    `json:"sequenced"`
+	Timestamped    bool    `json:"timestamped,omitempty"`
+	Codec          string  `json:"codec,omitempty"`
+	Cipher         string  `json:"cipher,omitempty"`
+	Checksum       string  `json:"checksum,omitempty"` // "" = ieee
+	KeyID          *uint32 `json:"key_id,omitempty"`   // current key of an encrypted cache
 }
 
 func newPersistedConfig(opts CacheOptions) persistedConfig {
-    return persistedConfig{
-        RecordSize: opts.RecordSize,
-        MinIDAlloc: opts.MinIDAlloc,
-        MaxIDAlloc: opts.MaxIDAlloc,
-        ShardCount: opts.ShardCount,
-    }
+	return persistedConfig{
+		RecordSize:     opts.RecordSize,
+		MinIDAlloc:     opts.MinIDAlloc,
+		MaxIDAlloc:     opts.MaxIDAlloc,
+		ShardCount:     opts.ShardCount,
+		VariableLength: opts.VariableLength,
+		Sequenced:      opts.Sequenced,
+		Timestamped:    opts.Timestamped,
+		Codec:          codecName(opts.Codec),
+		Cipher:         cipherNameOf(opts.Cipher),
+		Checksum:       checksumName(opts.Checksum),
+	}
+}
+
+// apply copies the persisted layout into opts. It fails when the persisted
+// codec is not registered in this build or the checksum is unknown, and when opts disagree on
+// encryption: a key provider cannot be adopted, and silently dropping a
+// requested Cipher would store plaintext.
+func (p persistedConfig) apply(opts *CacheOptions) error {
+	codec, err := lookupCodec(p.Codec)
+	if err != nil {
+		return err
+	}
+	sum, err := lookupChecksum(p.Checksum)
+	if err != nil {
+		return err
+	}
+	if want := cipherNameOf(opts.Cipher); p.Cipher != want {
+		if p.Cipher == "" {
+			return fmt.Errorf("cache is not encrypted; use ConfigMigrate to encrypt it")
+		}
+		return fmt.Errorf("cache is encrypted with %s; set CacheOptions.Cipher", p.Cipher)
+	}
+	if codecName(opts.Codec) != p.Codec {
+		opts.Codec = codec
+	}
+	opts.RecordSize = p.RecordSize
+	opts.MinIDAlloc = p.MinIDAlloc
+	opts.MaxIDAlloc = p.MaxIDAlloc
+	opts.ShardCount = p.ShardCount
+	opts.VariableLength = p.VariableLength
+	opts.Sequenced = p.Sequenced
+	opts.Timestamped = p.Timestamped
+	opts.Checksum = sum
+	return nil
+}
+
+// diff lists the fields where p (persisted) and want (requested) differ.
+func (p persistedConfig) diff(want persistedConfig) []FieldMismatch {
+	var out []FieldMismatch
+	add := func(field string, have, want any) {
+		if have != want {
+			out = append(out, FieldMismatch{Field: field, Persisted: have, Requested: want})
+		}
+	}
+	add("RecordSize", p.RecordSize, want.RecordSize)
+	add("MinIDAlloc", p.MinIDAlloc, want.MinIDAlloc)
+	add("MaxIDAlloc", p.MaxIDAlloc, want.MaxIDAlloc)
+	add("ShardCount", p.ShardCount, want.ShardCount)
+	add("VariableLength", p.VariableLength, want.VariableLength)
+	add("Sequenced", p.Sequenced, want.Sequenced)
+	add("Timestamped", p.Timestamped, want.Timestamped)
+	add("Codec", p.Codec, want.Codec)
+	add("Cipher", p.Cipher, want.Cipher)
+	add("Checksum", p.Checksum, want.Checksum)
+	return out
+}
+
+// FieldMismatch is one option that differs from the persisted layout.
+type FieldMismatch struct {
+	Field     string
+	Persisted any
+	Requested any
+}
+
+// ConfigMismatchError is returned under ConfigStrict when the supplied
+// options disagree with the .cfg file.
+type ConfigMismatchError struct {
+	Path   string
+	Fields []FieldMismatch
+}
+
+func (e *ConfigMismatchError) Error() string {
+	parts := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		parts[i] = fmt.Sprintf("%s: persisted %v, requested %v", f.Field, f.Persisted, f.Requested)
+	}
+	return fmt.Sprintf("config mismatch in %s: %s", e.Path, strings.Join(parts, "; "))
+}
+
+func readConfig(path string) (persistedConfig, error) {
+	var have persistedConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return have, fmt.Errorf("open config file: %w", err)
+	}
+	if err := json.Unmarshal(data, &have); err != nil {
+		return have, fmt.Errorf("decode config %s: %w", path, err)
+	}
+	return have, nil
+}
+
+func writeConfig(path string, cfg persistedConfig) error {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode config: %w", err)
+	}
+	if err := writeFileAtomic(path, append(data, '\n')); err != nil {
+		return fmt.Errorf("write config file: %w", err)
+	}
+	return nil
 }
 
 // verifyOrWriteConfig loads an existing .config file if present and verifies it
 // matches the supplied options. If the file does not exist, it is created.
-// On mismatch, it returns an error detailing the differences.
+// On mismatch, ConfigAdopt overrides opts with the persisted values while
+// ConfigStrict and ConfigMigrate return a *ConfigMismatchError.
 func verifyOrWriteConfig(path string, opts *CacheOptions) error {
-    want := newPersistedConfig(*opts)
-
-    if _, err := os.Stat(path); os.IsNotExist(err) {
-        // first time: write file
-        f, err := os.Create(path)
-        if err != nil {
-            return fmt.Errorf("create config file: %w", err)
-        }
-        defer f.Close()
-        enc := json.NewEncoder(f)
-        enc.SetIndent("", "  ")
-        if err := enc.Encode(want); err != nil {
-            return fmt.Errorf("encode config: %w", err)
-        }
-        return nil
-    }
-
-    // file exists, load & sync options
-    f, err := os.Open(path)
-    if err != nil {
-        return fmt.Errorf("open config file: %w", err)
-    }
-    defer f.Close()
-    var have persistedConfig
-    if err := json.NewDecoder(f).Decode(&have); err != nil {
-        return fmt.Errorf("decode config: %w", err)
-    }
-
-    // override supplied opts with persisted values to ensure consistency
-    opts.RecordSize = have.RecordSize
-    opts.MinIDAlloc = have.MinIDAlloc
-    opts.MaxIDAlloc = have.MaxIDAlloc
-    opts.ShardCount = have.ShardCount
-    return nil
+	want := newPersistedConfig(*opts)
+	if opts.Cipher != nil {
+		id, err := opts.Cipher.keys.CurrentKeyID()
+		if err != nil {
+			return fmt.Errorf("cipher: current key: %w", err)
+		}
+		want.KeyID = &id
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		// first time: write file
+		return writeConfig(path, want)
+	}
+
+	have, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+	if diff := have.diff(want); len(diff) > 0 && opts.ConfigPolicy != ConfigAdopt {
+		return &ConfigMismatchError{Path: path, Fields: diff}
+	}
+
+	// override supplied opts with persisted values to ensure consistency
+	if err := have.apply(opts); err != nil {
+		return fmt.Errorf("config %s: %w", path, err)
+	}
+	if want.KeyID != nil && (have.KeyID == nil || *have.KeyID != *want.KeyID) {
+		// record a key rotation
+		have.KeyID = want.KeyID
+		return writeConfig(path, have)
+	}
+	return nil
 }
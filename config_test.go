@@ -0,0 +1,199 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigStrictReportsMismatch(t *testing.T) {
+	cache, base := newTestCache(t, 10, 8)
+	cache.Close()
+
+	opts := DefaultOptions()
+	opts.UseMmap = false
+	opts.ShardCount = 1
+	opts.RecordSize = 16
+	opts.ConfigPolicy = ConfigStrict
+	_, err := NewRingBufferCacheWithOptions(base, opts)
+	var mismatch *ConfigMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected *ConfigMismatchError, got %v", err)
+	}
+	if len(mismatch.Fields) != 1 || mismatch.Fields[0].Field != "RecordSize" ||
+		mismatch.Fields[0].Persisted != 8 || mismatch.Fields[0].Requested != 16 {
+		t.Fatalf("unexpected mismatch fields %+v", mismatch.Fields)
+	}
+}
+
+func TestConfigStrictSequencedOptIn(t *testing.T) {
+	// a cache laid out without sequence numbers, as before Sequenced existed
+	opts := DefaultOptions()
+	opts.MaxIDAlloc = 10
+	cache, base := newTestCacheWithOpts(t, 10, 8, opts)
+	cache.Close()
+
+	opts = DefaultOptions()
+	opts.UseMmap = false
+	opts.MaxIDAlloc = 10
+	opts.ShardCount = 1
+	opts.RecordSize = 8
+	opts.ConfigPolicy = ConfigStrict
+	reopened, err := NewRingBufferCacheWithOptions(base, opts)
+	if err != nil {
+		t.Fatalf("DefaultOptions under ConfigStrict: %v", err)
+	}
+	reopened.Close()
+
+	opts.Sequenced = true
+	_, err = NewRingBufferCacheWithOptions(base, opts)
+	var mismatch *ConfigMismatchError
+	if !errors.As(err, &mismatch) || len(mismatch.Fields) != 1 || mismatch.Fields[0].Field != "Sequenced" {
+		t.Fatalf("expected a Sequenced mismatch, got %v", err)
+	}
+}
+
+func TestConfigUnreadableReturnsError(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "cache.data")
+	if err := os.WriteFile(base+".cfg", []byte("{not json"), 0o644); err != nil {
+		t.Fatalf("write cfg: %v", err)
+	}
+	opts := DefaultOptions()
+	opts.ShardCount = 1
+	if _, err := NewRingBufferCacheWithOptions(base, opts); err == nil {
+		t.Fatalf("expected error for unreadable config")
+	}
+}
+
+func TestConfigMigrateKeepsLiveWindow(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxIDAlloc = 6
+	cache, base := newTestCacheWithOpts(t, 6, 4, opts)
+	for i := 0; i < 9; i++ { // live window: seq 4..9
+		cache.WriteHead(bytes.Repeat([]byte{byte(i)}, 4), false)
+	}
+	cur, _ := cache.Cursor("app")
+	rec, _ := cur.Next()
+	cur.Commit(rec.ID) // committed seq 4
+	cache.Close()
+
+	opts = DefaultOptions()
+	opts.UseMmap = false
+	opts.RecordSize = 4
+	opts.MaxIDAlloc = 20
+	opts.ShardCount = 3
+	opts.ConfigPolicy = ConfigMigrate
+	migrated, err := NewRingBufferCacheWithOptions(base, opts)
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	defer migrated.Close()
+
+	if migrated.Size() != 20 || migrated.ShardCount() != 3 || migrated.Head() != 3 || migrated.Tail() != 4 {
+		t.Fatalf("unexpected layout size=%d shards=%d head=%d tail=%d",
+			migrated.Size(), migrated.ShardCount(), migrated.Head(), migrated.Tail())
+	}
+	for id := int64(1); id <= 6; id++ { // every record keeps its ID
+		seq := id
+		if id <= 3 {
+			seq += 6
+		}
+		p, err := migrated.Read(id)
+		if err != nil || p[0] != byte(seq-1) {
+			t.Fatalf("id %d after migrate: %v %v", id, p, err)
+		}
+	}
+	if _, err := os.Stat(base); !os.IsNotExist(err) {
+		t.Fatalf("old single-shard file should be gone")
+	}
+	if _, err := os.Stat(swapPlanPath(base)); !os.IsNotExist(err) {
+		t.Fatalf("swap plan should be removed")
+	}
+	cur, _ = migrated.Cursor("app")
+	if rec, err := cur.Next(); err != nil || rec.ID != 5 || rec.Payload[0] != 4 {
+		t.Fatalf("cursor after migrate: %+v %v", rec, err)
+	}
+}
+
+func TestConfigMigrateWrittenIDs(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxIDAlloc = 8
+	cache, base := newTestCacheWithOpts(t, 8, 4, opts)
+	written := map[int64]string{2: "aaaa", 5: "bbbb", 8: "cccc"}
+	for id, p := range written {
+		if err := cache.Write(id, []byte(p), false); err != nil {
+			t.Fatalf("Write(%d): %v", id, err)
+		}
+	}
+	cache.Close()
+
+	opts.UseMmap = false
+	opts.RecordSize = 4
+	opts.ShardCount = 2
+	opts.Checksum = ChecksumCRC32C
+	opts.ConfigPolicy = ConfigMigrate
+	migrated, err := NewRingBufferCacheWithOptions(base, opts)
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	for id, want := range written {
+		if got, err := migrated.Read(id); err != nil || string(got) != want {
+			t.Fatalf("Read(%d) after migrate = %q, %v", id, got, err)
+		}
+	}
+	migrated.Close()
+
+	// a record outside the narrowed range fails the migration
+	opts.MaxIDAlloc = 6
+	if _, err := NewRingBufferCacheWithOptions(base, opts); err == nil {
+		t.Fatalf("migration dropping id 8 succeeded")
+	}
+	opts.ConfigPolicy = ConfigAdopt
+	kept, err := NewRingBufferCacheWithOptions(base, opts)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer kept.Close()
+	if got, err := kept.Read(8); kept.Size() != 8 || err != nil || string(got) != "cccc" {
+		t.Fatalf("after failed migration size=%d Read(8) = %q, %v", kept.Size(), got, err)
+	}
+}
+
+func TestResumeInterruptedSwap(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "cache.data")
+	tmp := base + ".migrate"
+	for _, f := range []string{base, base + ".cfg", tmp, tmp + ".cfg"} {
+		os.WriteFile(f, []byte(filepath.Base(f)), 0o644)
+	}
+	plan := planSwap(base, 1, tmp, 1)
+	// simulate a crash after the shard rename but before .cfg
+	os.Rename(plan.Renames[0].From, plan.Renames[0].To)
+	if err := writeFileAtomic(swapPlanPath(base), mustJSON(t, plan)); err != nil {
+		t.Fatalf("write plan: %v", err)
+	}
+
+	if err := resumeSwap(base); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	for _, f := range []string{base, base + ".cfg"} {
+		if data, _ := os.ReadFile(f); !bytes.HasPrefix(data, []byte("cache.data.migrate")) {
+			t.Fatalf("%s not swapped: %q", f, data)
+		}
+	}
+	if _, err := os.Stat(swapPlanPath(base)); !os.IsNotExist(err) {
+		t.Fatalf("swap plan should be removed")
+	}
+}
+
+func mustJSON(t *testing.T, v any) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return data
+}
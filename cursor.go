@@ -0,0 +1,253 @@
+package archive
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrCaughtUp is returned by Cursor.Next when the cursor has already read
+// every committed record.
+var ErrCaughtUp = errors.New("cursor caught up with head")
+
+// cursor file layout: 16 bytes (little-endian), stored as <base>.cursor.<name>
+// 0..7  : uint64 seq of the last committed record (0 = nothing committed)
+// 8..15 : uint64 ID of the last committed record (informational)
+
+// Cursor is a durable, named read position for one consumer group. Progress
+// recorded with Commit survives restarts; records read with Next but not yet
+// committed are delivered again after a restart.
+//
+// A Cursor is safe for concurrent use; all Cursor(name) calls for the same
+// name on one cache return the same value.
+type Cursor struct {
+	c    *RingBufferCache
+	name string
+	path string
+
+	mu        sync.Mutex
+	next      uint64 // seq of the next record Next will look at
+	committed uint64 // seq of the last committed record
+}
+
+func cursorPath(base, name string) string { return base + ".cursor." + name }
+
+func validCursorName(name string) bool {
+	if name == "" || len(name) > 128 {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Cursor returns the named consumer cursor, loading its committed position
+// from disk. A cursor that has never committed starts at Tail().
+func (c *RingBufferCache) Cursor(name string) (*Cursor, error) {
+	if !validCursorName(name) {
+		return nil, fmt.Errorf("invalid cursor name %q", name)
+	}
+
+	c.cursorMu.Lock()
+	defer c.cursorMu.Unlock()
+	if cur, ok := c.cursors[name]; ok {
+		return cur, nil
+	}
+
+	cur := &Cursor{c: c, name: name, path: cursorPath(c.basePath, name)}
+	committed, err := loadCursor(cur.path)
+	switch {
+	case err == nil:
+		cur.committed = committed
+		cur.next = committed + 1
+	case os.IsNotExist(err):
+		cur.next = c.oldestSeq()
+		cur.committed = cur.next - 1
+	default:
+		return nil, fmt.Errorf("load cursor %q: %w", name, err)
+	}
+
+	if c.cursors == nil {
+		c.cursors = make(map[string]*Cursor)
+	}
+	c.cursors[name] = cur
+	return cur, nil
+}
+
+func loadCursor(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) < 16 {
+		return 0, fmt.Errorf("cursor file too small")
+	}
+	return binary.LittleEndian.Uint64(data[0:8]), nil
+}
+
+// cursorNames returns the names of the cursor files of the cache at base.
+// The directory is listed rather than globbed, as base may contain pattern
+// characters.
+func cursorNames(base string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Dir(base))
+	if err != nil {
+		return nil, err
+	}
+	prefix := filepath.Base(cursorPath(base, ""))
+	var names []string
+	for _, e := range entries {
+		if name, ok := strings.CutPrefix(e.Name(), prefix); ok && validCursorName(name) && !e.IsDir() {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// loadCursors returns the committed sequence of every cursor file of the
+// cache at base, by name.
+func loadCursors(base string) (map[string]uint64, error) {
+	names, err := cursorNames(base)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]uint64, len(names))
+	for _, name := range names {
+		seq, err := loadCursor(cursorPath(base, name))
+		if err != nil {
+			return nil, fmt.Errorf("load cursor %q: %w", name, err)
+		}
+		out[name] = seq
+	}
+	return out, nil
+}
+
+// reloadCursors reloads the committed position of every open cursor after a
+// layout change rewrote the cursor files. Records read but not committed are
+// delivered again, as after a restart. The cache must be paused.
+func (c *RingBufferCache) reloadCursors() error {
+	c.cursorMu.Lock()
+	defer c.cursorMu.Unlock()
+	for name, cur := range c.cursors {
+		committed, err := loadCursor(cur.path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("load cursor %q: %w", name, err)
+		}
+		cur.mu.Lock()
+		if err == nil {
+			cur.committed, cur.next = committed, committed+1
+		} else {
+			cur.next = c.oldestSeq()
+			cur.committed = cur.next - 1
+		}
+		cur.mu.Unlock()
+	}
+	return nil
+}
+
+func saveCursor(path string, seq uint64, id int64) error {
+	buf := make([]byte, 16)
+	binary.LittleEndian.PutUint64(buf[0:8], seq)
+	binary.LittleEndian.PutUint64(buf[8:16], uint64(id))
+	return writeFileAtomic(path, buf)
+}
+
+// Name returns the cursor name.
+func (cur *Cursor) Name() string { return cur.name }
+
+// Next returns the next record after the cursor's read position and advances
+// it. It returns ErrCaughtUp when there is nothing new; combine with
+// Subscribe or a poll on Head() to wait. When the producer overwrote unread
+// records the returned Record carries an error wrapping ErrLapped and the
+// cursor continues from the oldest record still in the ring.
+func (cur *Cursor) Next() (Record, error) {
+	if err := cur.c.enter(); err != nil {
+		return Record{}, err
+	}
+	defer cur.c.exit()
+
+	cur.mu.Lock()
+	defer cur.mu.Unlock()
+
+	rec, after, ok := cur.c.readFrom(cur.next)
+	cur.next = after
+	if !ok {
+		return Record{}, ErrCaughtUp
+	}
+	return rec, rec.Err
+}
+
+// Commit durably records id, as returned by Next, as processed. IDs are
+// resolved relative to the read position, so committing the ID of the most
+// recently returned record commits everything read so far.
+func (cur *Cursor) Commit(id int64) error {
+	c := cur.c
+	if err := c.enter(); err != nil {
+		return err
+	}
+	defer c.exit()
+	c.layoutMu.RLock()
+	defer c.layoutMu.RUnlock()
+	if _, err := c.absToRel(id); err != nil {
+		return err
+	}
+
+	cur.mu.Lock()
+	defer cur.mu.Unlock()
+
+	if cur.next <= 1 {
+		return fmt.Errorf("cursor %q: id %d has not been read", cur.name, id)
+	}
+	// latest sequence below the read position that maps to id
+	last := cur.next - 1
+	back := uint64((c.idForSeq(last) - id + c.size) % c.size)
+	if back >= last {
+		return fmt.Errorf("cursor %q: id %d has not been read", cur.name, id)
+	}
+	seq := last - back
+	if seq <= cur.committed {
+		return nil
+	}
+	if err := saveCursor(cur.path, seq, id); err != nil {
+		return fmt.Errorf("save cursor %q: %w", cur.name, err)
+	}
+	cur.committed = seq
+	return nil
+}
+
+// Committed returns the ID of the last committed record, or MinIDAlloc-1
+// when nothing has been committed yet.
+func (cur *Cursor) Committed() int64 {
+	cur.mu.Lock()
+	defer cur.mu.Unlock()
+	cur.c.layoutMu.RLock()
+	defer cur.c.layoutMu.RUnlock()
+	if cur.committed == 0 {
+		return cur.c.minIDAlloc - 1
+	}
+	return cur.c.idForSeq(cur.committed)
+}
+
+// Lag returns how many slots were committed by WriteHead after the cursor's
+// last committed record. A lag of Size() or more means unread records have
+// already been overwritten.
+func (cur *Cursor) Lag() int64 {
+	cur.mu.Lock()
+	committed := cur.committed
+	cur.mu.Unlock()
+	head := atomic.LoadUint64(&cur.c.commitSeq)
+	if head <= committed {
+		return 0
+	}
+	return int64(head - committed)
+}
@@ -0,0 +1,84 @@
+package archive
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCursorSurvivesRestart(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxIDAlloc = 8
+	cache, base := newTestCacheWithOpts(t, 8, 1, opts)
+	for i := 0; i < 5; i++ {
+		if _, err := cache.WriteHead([]byte{byte(i)}, i == 4); err != nil {
+			t.Fatalf("WriteHead: %v", err)
+		}
+	}
+
+	cur, err := cache.Cursor("billing")
+	if err != nil {
+		t.Fatalf("Cursor: %v", err)
+	}
+	var last Record
+	for i := 0; i < 3; i++ {
+		if last, err = cur.Next(); err != nil || last.Payload[0] != byte(i) {
+			t.Fatalf("Next #%d: %+v, %v", i, last, err)
+		}
+	}
+	if err := cur.Commit(last.ID); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if lag := cur.Lag(); lag != 2 {
+		t.Fatalf("expected lag 2, got %d", lag)
+	}
+	cache.Close()
+
+	opts.UseMmap = false
+	opts.ShardCount = 1
+	opts.RecordSize = 1
+	reopened, err := NewRingBufferCacheWithOptions(base, opts)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+
+	cur, err = reopened.Cursor("billing")
+	if err != nil {
+		t.Fatalf("Cursor after reopen: %v", err)
+	}
+	if cur.Committed() != last.ID {
+		t.Fatalf("expected committed id %d, got %d", last.ID, cur.Committed())
+	}
+	for want := byte(3); want < 5; want++ {
+		if rec, err := cur.Next(); err != nil || rec.Payload[0] != want {
+			t.Fatalf("expected record %d after restart, got %+v, %v", want, rec, err)
+		}
+	}
+	if _, err := cur.Next(); !errors.Is(err, ErrCaughtUp) {
+		t.Fatalf("expected ErrCaughtUp, got %v", err)
+	}
+}
+
+func TestCursorLapped(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxIDAlloc = 3
+	cache, _ := newTestCacheWithOpts(t, 3, 1, opts)
+	defer cache.Close()
+
+	cur, err := cache.Cursor("slow")
+	if err != nil {
+		t.Fatalf("Cursor: %v", err)
+	}
+	for i := 0; i < 7; i++ {
+		cache.WriteHead([]byte{byte(i)}, false)
+	}
+	if _, err := cur.Next(); !errors.Is(err, ErrLapped) {
+		t.Fatalf("expected ErrLapped, got %v", err)
+	}
+	if rec, err := cur.Next(); err != nil || rec.Payload[0] != 4 {
+		t.Fatalf("expected oldest record 4 after lap, got %+v, %v", rec, err)
+	}
+	if _, err := cache.Cursor("../escape"); err == nil {
+		t.Fatalf("expected invalid cursor name error")
+	}
+}
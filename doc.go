@@ -9,7 +9,34 @@
 //	cache.go        – constructors & core fields
 //	shard_lookup.go – helper to locate a shard for an ID
 //	buffer.go       – pooled buffer & lock helpers
+//	slot.go         – slot header layout (CRC, variable-length info)
+//	seq.go          – per-slot sequence numbers & ReadSeq
+//	subscribe.go    – blocking tail-follow subscriptions
+//	cursor.go       – durable named consumer cursors
+//	meta.go         – crash-safe .meta persistence
+//	recovery.go     – crash recovery scan on open
+//	errors.go       – sentinel & structured error types
+//	config.go       – persisted layout & ConfigPolicy
+//	migrate.go      – layout migration & atomic file swap
+//	resize.go       – online ring resize & reader layout reload
+//	reshard.go      – restartable ShardCount change (cmd/archivectl)
 //	io.go           – read/write logic & CRC integrity
+//	timeindex.go    – per-record timestamps & time lookups
+//	batch.go        – batched writes, range msync & group commit
+//	durability.go   – durability policy & background flusher
+//	view.go         – zero-copy View & ReadInto
+//	codec.go        – per-record compression codecs & registry
+//	lz4.go          – pure Go LZ4 block format
+//	codec_zstd.go   – zstd codec (build tag zstd)
+//	checksum.go     – selectable slot checksums (CRC32, CRC32C, xxHash64)
+//	cipher.go       – AES-256-GCM encryption at rest & key rotation
+//	scan.go         – iter.Seq2 iterators over the live window
+//	tombstone.go    – tombstones, DeleteRange & hole punching
+//	retention.go    – age/count retention & tail trimming
+//	lock.go         – single-writer flock & OpenReadOnly
+//	shared.go       – live head/tail shared across processes
+//	notify.go       – WaitForHead & cross-process futex wake-ups
+//	prefetch.go     – bounded read-ahead worker pool
 //	stats.go        – lightweight stats accessors
 //	flush_close.go  – flush & close helpers
 //
@@ -0,0 +1,160 @@
+package archive
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// DurabilityMode selects when written records are synced to disk.
+type DurabilityMode int
+
+const (
+	// DurabilityPerCall syncs exactly when the flush argument of a write
+	// asks for it (the historical behaviour).
+	DurabilityPerCall DurabilityMode = iota
+	// DurabilityAlways syncs every write as if flush were true.
+	DurabilityAlways
+	// DurabilityEveryN has a background flusher sync data and .meta after
+	// every DurabilityPolicy.Records records written without a sync, by
+	// WriteHead, WriteHeadBatch, Write or BulkWrite alike.
+	DurabilityEveryN
+	// DurabilityEveryT has a background flusher sync data and .meta every
+	// DurabilityPolicy.Interval.
+	DurabilityEveryT
+	// DurabilityOS never syncs on writes, ignoring the flush argument; data
+	// reaches disk through OS writeback, Flush or Close.
+	DurabilityOS
+)
+
+// DurabilityPolicy configures when the cache makes writes durable. Under
+// DurabilityEveryN and DurabilityEveryT a flush=true write is still synced
+// immediately.
+type DurabilityPolicy struct {
+	Mode     DurabilityMode
+	Records  int           // DurabilityEveryN: records per background sync
+	Interval time.Duration // DurabilityEveryT: time between background syncs
+}
+
+// validate checks that the policy names a mode and everything it needs.
+func (p DurabilityPolicy) validate() error {
+	switch {
+	case p.Mode < DurabilityPerCall || p.Mode > DurabilityOS:
+		return fmt.Errorf("%w: unknown mode %d", ErrInvalidDurability, p.Mode)
+	case p.Mode == DurabilityEveryN && p.Records <= 0:
+		return fmt.Errorf("%w: DurabilityEveryN needs a positive Records", ErrInvalidDurability)
+	case p.Mode == DurabilityEveryT && p.Interval <= 0:
+		return fmt.Errorf("%w: DurabilityEveryT needs a positive Interval", ErrInvalidDurability)
+	}
+	return nil
+}
+
+// wantSync reports whether a write with the given flush argument must be
+// synced before returning.
+func (c *RingBufferCache) wantSync(flush bool) bool {
+	switch c.options.Durability.Mode {
+	case DurabilityAlways:
+		return true
+	case DurabilityOS:
+		return false
+	}
+	return flush
+}
+
+// LastDurableID returns the ID of the newest record written by WriteHead
+// that, together with every record before it, has been synced to disk and
+// would survive a power loss. It returns MinIDAlloc-1 when nothing is known
+// to be durable yet.
+func (c *RingBufferCache) LastDurableID() int64 {
+	c.layoutMu.RLock()
+	defer c.layoutMu.RUnlock()
+	seq := atomic.LoadUint64(&c.durableSeq)
+	if seq == 0 {
+		return c.minIDAlloc - 1
+	}
+	return c.idForSeq(seq)
+}
+
+// markDurable records that the slots with sequence numbers from..to have
+// been synced. The durable point only advances over a gap-free prefix.
+func (c *RingBufferCache) markDurable(from, to uint64) {
+	for {
+		cur := atomic.LoadUint64(&c.durableSeq)
+		if cur >= to || cur+1 < from {
+			return
+		}
+		if atomic.CompareAndSwapUint64(&c.durableSeq, cur, to) {
+			return
+		}
+	}
+}
+
+// noteUnsynced counts records written without a sync and wakes the
+// background flusher once DurabilityEveryN is reached.
+func (c *RingBufferCache) noteUnsynced(n int) {
+	if c.options.Durability.Mode != DurabilityEveryN {
+		return
+	}
+	if atomic.AddInt64(&c.unsynced, int64(n)) >= int64(c.options.Durability.Records) {
+		select {
+		case c.flushKick <- struct{}{}:
+		default: // a sync is already pending
+		}
+	}
+}
+
+// startFlusher launches the background flusher for DurabilityEveryN and
+// DurabilityEveryT. It exits when the cache is closed.
+func (c *RingBufferCache) startFlusher() {
+	p := c.options.Durability
+	var tick <-chan time.Time
+	switch p.Mode {
+	case DurabilityEveryN:
+		c.flushKick = make(chan struct{}, 1)
+	case DurabilityEveryT:
+		t := time.NewTicker(p.Interval)
+		tick = t.C
+		go func() {
+			<-c.done
+			t.Stop()
+		}()
+	default:
+		return
+	}
+
+	go func() {
+		for {
+			select {
+			case <-c.done:
+				return
+			case <-tick:
+			case <-c.flushKick:
+			}
+			if err := c.syncAll(); err != nil {
+				atomic.AddUint64(&c.flushErrors, 1)
+			}
+		}
+	}()
+}
+
+// syncAll flushes every shard and .meta and advances the durable point to
+// what was committed before the flush started.
+func (c *RingBufferCache) syncAll() error {
+	if err := c.enterWrite(); err != nil {
+		return err
+	}
+	defer c.exit()
+
+	target := atomic.LoadUint64(&c.commitSeq)
+	pending := atomic.SwapInt64(&c.unsynced, 0)
+	if err := c.flush(); err != nil {
+		atomic.AddInt64(&c.unsynced, pending)
+		return err
+	}
+	if err := c.saveMeta(c.metaState(false)); err != nil {
+		atomic.AddInt64(&c.unsynced, pending)
+		return err
+	}
+	c.markDurable(0, target)
+	return nil
+}
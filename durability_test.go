@@ -0,0 +1,124 @@
+package archive
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newDurabilityCache(t *testing.T, p DurabilityPolicy) *RingBufferCache {
+	t.Helper()
+	opts := DefaultOptions()
+	opts.MaxIDAlloc = 16
+	opts.Durability = p
+	cache, _ := newTestCacheWithOpts(t, 16, 4, opts)
+	t.Cleanup(func() { cache.Close() })
+	return cache
+}
+
+func waitDurable(t *testing.T, c *RingBufferCache, want int64) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for c.LastDurableID() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("LastDurableID = %d, want %d", c.LastDurableID(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDurabilityPerCall(t *testing.T) {
+	cache := newDurabilityCache(t, DurabilityPolicy{})
+	if got := cache.LastDurableID(); got != 0 {
+		t.Fatalf("fresh cache LastDurableID = %d", got)
+	}
+	cache.WriteHead([]byte("aaaa"), true)
+	cache.WriteHead([]byte("bbbb"), false)
+	if got := cache.LastDurableID(); got != 1 {
+		t.Fatalf("LastDurableID = %d, want 1", got)
+	}
+	// a synced record behind an unsynced one does not advance the point
+	cache.WriteHead([]byte("cccc"), true)
+	if got := cache.LastDurableID(); got != 1 {
+		t.Fatalf("LastDurableID = %d after gap, want 1", got)
+	}
+	if err := cache.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if got := cache.LastDurableID(); got != 3 {
+		t.Fatalf("LastDurableID = %d after Flush, want 3", got)
+	}
+}
+
+func TestDurabilityModes(t *testing.T) {
+	always := newDurabilityCache(t, DurabilityPolicy{Mode: DurabilityAlways})
+	always.WriteHead([]byte("aaaa"), false)
+	if got := always.LastDurableID(); got != 1 {
+		t.Fatalf("Always: LastDurableID = %d, want 1", got)
+	}
+
+	osOnly := newDurabilityCache(t, DurabilityPolicy{Mode: DurabilityOS})
+	osOnly.WriteHead([]byte("aaaa"), true)
+	if got := osOnly.LastDurableID(); got != 0 {
+		t.Fatalf("OS: LastDurableID = %d, want 0", got)
+	}
+
+	everyN := newDurabilityCache(t, DurabilityPolicy{Mode: DurabilityEveryN, Records: 3})
+	everyN.WriteHead([]byte("aaaa"), false)
+	everyN.WriteHead([]byte("bbbb"), false)
+	time.Sleep(10 * time.Millisecond)
+	if got := everyN.LastDurableID(); got != 0 {
+		t.Fatalf("EveryN: synced before N records, LastDurableID = %d", got)
+	}
+	everyN.WriteHeadBatch([][]byte{[]byte("cccc"), []byte("dddd")}, false)
+	waitDurable(t, everyN, 4)
+
+	// records written by ID count as well
+	byID := newDurabilityCache(t, DurabilityPolicy{Mode: DurabilityEveryN, Records: 3})
+	byID.WriteHead([]byte("aaaa"), false)
+	byID.Write(8, []byte("bbbb"), false)
+	time.Sleep(10 * time.Millisecond)
+	if got := byID.LastDurableID(); got != 0 {
+		t.Fatalf("EveryN: synced before N records, LastDurableID = %d", got)
+	}
+	byID.BulkWrite(10, [][]byte{[]byte("cccc")}, false)
+	waitDurable(t, byID, 1)
+
+	everyT := newDurabilityCache(t, DurabilityPolicy{Mode: DurabilityEveryT, Interval: 5 * time.Millisecond})
+	everyT.WriteHead([]byte("aaaa"), false)
+	waitDurable(t, everyT, 1)
+	if st := everyT.GetStats(); st.FlushErrors != 0 {
+		t.Fatalf("background flush errors: %d", st.FlushErrors)
+	}
+}
+
+func TestDurableAfterReopen(t *testing.T) {
+	cache, base := newTestCache(t, 16, 4)
+	cache.WriteHead([]byte("aaaa"), false)
+	cache.WriteHead([]byte("bbbb"), false)
+	cache.Close()
+
+	reopened := reopenTestCache(t, base, 4)
+	defer reopened.Close()
+	if got := reopened.LastDurableID(); got != 2 {
+		t.Fatalf("LastDurableID after reopen = %d, want 2", got)
+	}
+}
+
+func TestDurabilityInvalid(t *testing.T) {
+	for _, p := range []DurabilityPolicy{
+		{Mode: DurabilityEveryN},
+		{Mode: DurabilityEveryN, Records: -1},
+		{Mode: DurabilityEveryT},
+		{Mode: DurabilityOS + 1},
+	} {
+		opts := DefaultOptions()
+		opts.MaxIDAlloc = 16
+		opts.Durability = p
+		_, err := NewRingBufferCacheWithOptions(filepath.Join(t.TempDir(), "cache.dat"), opts)
+		if !errors.Is(err, ErrInvalidDurability) {
+			t.Fatalf("policy %+v: %v", p, err)
+		}
+	}
+}
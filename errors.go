@@ -0,0 +1,88 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sentinel errors. Every error returned by the cache that falls into one of
+// these classes wraps the matching sentinel, so callers can test for it with
+// errors.Is instead of matching message text.
+var (
+	// ErrCorrupted means a slot failed checksum verification or a
+	// multi-slot record is broken.
+	ErrCorrupted = errors.New("record corrupted")
+	// ErrOutOfRange means an ID, ID range or sequence number lies outside
+	// what the cache holds.
+	ErrOutOfRange = errors.New("id out of range")
+	// ErrPayloadSize means a payload has the wrong length for a fixed-size
+	// cache or is too large for a VariableLength one.
+	ErrPayloadSize = errors.New("invalid payload size")
+	// ErrClosed means the cache, or the shard file behind it, is closed.
+	ErrClosed = errors.New("cache closed")
+	// ErrOverwritten is returned by ReadSeq when the slot that held the
+	// requested sequence number has since been reused by a newer record,
+	// i.e. the consumer fell more than one full ring behind the producer.
+	ErrOverwritten = errors.New("record overwritten")
+	// ErrExpired means the record is older than the RetentionPolicy allows
+	// and must no longer be read, even if its slot was not reused yet.
+	ErrExpired = errors.New("record expired")
+	// ErrDeleted means the record was removed with Delete or DeleteRange.
+	ErrDeleted = errors.New("record deleted")
+	// ErrLocked means another writer holds the cache open.
+	ErrLocked = errors.New("cache locked by another writer")
+	// ErrReadOnly means a write was attempted on a cache opened with
+	// OpenReadOnly.
+	ErrReadOnly = errors.New("cache opened read-only")
+	// ErrInvalidDurability means a cache was opened with a DurabilityPolicy
+	// that cannot be carried out: an unknown mode, DurabilityEveryN without
+	// a positive Records, or DurabilityEveryT without a positive Interval.
+	ErrInvalidDurability = errors.New("invalid durability policy")
+)
+
+// RecordError reports a failure tied to one record. Err wraps one of the
+// sentinel errors above or the underlying I/O error; use errors.As to get at
+// the location.
+type RecordError struct {
+	ID     int64 // absolute record ID
+	Shard  int   // shard index, -1 when the ID maps to no shard
+	Offset int64 // byte offset of the slot within the shard, -1 when unknown
+	Err    error
+}
+
+func (e *RecordError) Error() string {
+	if e.Shard < 0 {
+		return fmt.Sprintf("id %d: %v", e.ID, e.Err)
+	}
+	return fmt.Sprintf("id %d (shard %d, offset %d): %v", e.ID, e.Shard, e.Offset, e.Err)
+}
+
+func (e *RecordError) Unwrap() error { return e.Err }
+
+// ShardError reports a failure of a whole shard file, e.g. during Flush or
+// Close.
+type ShardError struct {
+	Op    string // "msync", "fsync", "munmap", "close", ...
+	Shard int
+	Path  string
+	Err   error
+}
+
+func (e *ShardError) Error() string {
+	return fmt.Sprintf("%s shard %d (%s): %v", e.Op, e.Shard, e.Path, e.Err)
+}
+
+func (e *ShardError) Unwrap() error { return e.Err }
+
+// recordErr wraps err with the location of id. Errors that already carry a
+// location are returned unchanged.
+func (c *RingBufferCache) recordErr(id int64, s *shard, offset int64, err error) error {
+	var re *RecordError
+	if err == nil || errors.As(err, &re) {
+		return err
+	}
+	if s == nil {
+		return &RecordError{ID: id, Shard: -1, Offset: -1, Err: err}
+	}
+	return &RecordError{ID: id, Shard: s.index, Offset: offset, Err: err}
+}
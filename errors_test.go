@@ -0,0 +1,87 @@
+package archive
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestErrorsOutOfRangeAndPayloadSize(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxIDAlloc = 10
+	cache, _ := newTestCacheWithOpts(t, 10, 8, opts)
+	defer cache.Close()
+
+	_, err := cache.Read(11)
+	var re *RecordError
+	if !errors.Is(err, ErrOutOfRange) || !errors.As(err, &re) || re.ID != 11 || re.Shard != -1 {
+		t.Fatalf("read out of range: %v", err)
+	}
+	if _, err := cache.BulkRead(8, 5); !errors.Is(err, ErrOutOfRange) {
+		t.Fatalf("bulk read out of range: %v", err)
+	}
+
+	err = cache.Write(3, make([]byte, 7), false)
+	if !errors.Is(err, ErrPayloadSize) || !errors.As(err, &re) || re.ID != 3 {
+		t.Fatalf("short payload: %v", err)
+	}
+	if _, err := cache.WriteHead(make([]byte, 9), false); !errors.Is(err, ErrPayloadSize) {
+		t.Fatalf("long payload: %v", err)
+	}
+}
+
+func TestErrorsCorruptedCarriesLocation(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "cache.data")
+	opts := DefaultOptions()
+	opts.UseMmap = false
+	opts.RecordSize = 8
+	opts.MaxIDAlloc = 10
+	opts.ShardCount = 2
+	cache, err := NewRingBufferCacheWithOptions(base, opts)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer cache.Close()
+
+	id := int64(8) // second shard, third slot
+	if err := cache.Write(id, []byte("abcdefgh"), true); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	wantOff := int64(2 * cache.diskRec)
+	f, _ := os.OpenFile(shardPath(base, 2, 1), os.O_RDWR, 0)
+	f.WriteAt([]byte{0xFF}, wantOff+int64(cache.layout.hdrSize))
+	f.Close()
+
+	_, err = cache.Read(id)
+	var re *RecordError
+	if !errors.Is(err, ErrCorrupted) || !errors.As(err, &re) {
+		t.Fatalf("expected corrupted record error, got %v", err)
+	}
+	if re.ID != id || re.Shard != 1 || re.Offset != wantOff {
+		t.Fatalf("unexpected location %+v", re)
+	}
+}
+
+func TestErrorsOverwrittenAndClosed(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxIDAlloc = 4
+	opts.Sequenced = true
+	cache, _ := newTestCacheWithOpts(t, 4, 4, opts)
+	for i := 0; i < 6; i++ {
+		cache.WriteHead([]byte("abcd"), false)
+	}
+	_, err := cache.ReadSeq(1)
+	var re *RecordError
+	if !errors.Is(err, ErrOverwritten) || !errors.As(err, &re) || re.ID != 1 {
+		t.Fatalf("expected overwritten, got %v", err)
+	}
+	if _, err := cache.ReadSeq(7); !errors.Is(err, ErrOutOfRange) {
+		t.Fatalf("expected out of range for unwritten seq, got %v", err)
+	}
+
+	cache.Close()
+	if _, err := cache.Read(2); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed after Close, got %v", err)
+	}
+}
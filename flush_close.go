@@ -2,21 +2,78 @@ package archive
 
 import (
 	"fmt"
+	"sync/atomic"
 
 	"golang.org/x/sys/unix"
 )
 
-// Flush memaksa semua data tersimpan ke disk.
+// enter registers a public operation. It waits while the cache is paused by
+// Resize and fails with ErrClosed once Close has started; every successful
+// enter must be paired with exit. A read-only cache whose writer replaced the
+// shard files reopens them first.
+func (c *RingBufferCache) enter() error {
+	for {
+		c.closeMu.Lock()
+		for c.resizing && !c.closed {
+			c.drained.Wait()
+		}
+		if c.closed {
+			c.closeMu.Unlock()
+			return ErrClosed
+		}
+		c.inflight++
+		c.refreshShared()
+		// checked after the refresh: the writer bumps the epoch before it
+		// publishes anything written to the new files
+		if !c.layoutStale() {
+			c.closeMu.Unlock()
+			return nil
+		}
+		c.inflight--
+		if c.inflight == 0 {
+			c.drained.Broadcast()
+		}
+		c.closeMu.Unlock()
+		if err := c.reloadLayout(); err != nil {
+			return err
+		}
+	}
+}
+
+// exit ends an operation registered with enter and wakes Close when the
+// last one finishes.
+func (c *RingBufferCache) exit() {
+	c.closeMu.Lock()
+	c.inflight--
+	if c.inflight == 0 {
+		c.drained.Broadcast()
+	}
+	c.closeMu.Unlock()
+}
+
+// Closed reports whether Close has been called.
+func (c *RingBufferCache) Closed() bool {
+	c.closeMu.Lock()
+	defer c.closeMu.Unlock()
+	return c.closed
+}
+
+// Flush memaksa semua data dan .meta tersimpan ke disk, lalu memajukan
+// LastDurableID.
 func (c *RingBufferCache) Flush() error {
+	return c.syncAll()
+}
+
+func (c *RingBufferCache) flush() error {
 	var firstErr error
 	for i, s := range c.shards {
 		if s.mmap != nil {
 			if err := unix.Msync(s.mmap, unix.MS_SYNC); err != nil && firstErr == nil {
-				firstErr = fmt.Errorf("gagal msync shard %d: %w", i, err)
+				firstErr = &ShardError{Op: "msync", Shard: i, Path: s.filePath, Err: err}
 			}
 		} else {
 			if err := s.file.Sync(); err != nil && firstErr == nil {
-				firstErr = fmt.Errorf("gagal sync shard %d: %w", i, err)
+				firstErr = &ShardError{Op: "fsync", Shard: i, Path: s.filePath, Err: err}
 			}
 		}
 	}
@@ -24,17 +81,60 @@ func (c *RingBufferCache) Flush() error {
 }
 
 // Close menutup semua sumber daya (file & mmap) milik cache.
+//
+// Operasi baru ditolak dengan ErrClosed, lalu Close menunggu operasi yang
+// sedang berjalan dan worker prefetch selesai sebelum unmap, sehingga
+// tidak ada akses ke region yang sudah dilepas. Data di-flush dan .meta
+// ditandai "clean" sehingga open berikutnya dapat melewati recovery scan.
+// Subscription aktif ditutup. Panggilan Close berikutnya tidak melakukan
+// apa-apa dan mengembalikan nil.
+//
+// A writer releases its lock last. Closing a read-only cache writes nothing.
 func (c *RingBufferCache) Close() error {
+	return c.shutdown(!c.readOnly)
+}
+
+// shutdown closes the cache; with persist unset it leaves the files as a
+// crash would, for the next open to recover.
+func (c *RingBufferCache) shutdown(persist bool) error {
+	c.closeMu.Lock()
+	if c.closed {
+		c.closeMu.Unlock()
+		return nil
+	}
+	c.closed = true
+	for c.inflight > 0 || c.resizing {
+		c.drained.Wait()
+	}
+	c.closeMu.Unlock()
+
+	close(c.done)
+	if c.prefetcher != nil {
+		c.prefetcher.stop()
+	}
+	c.stopWatcher()
+
 	var firstErr error
-	for i, s := range c.shards {
-		if s.mmap != nil {
-			if err := unix.Munmap(s.mmap); err != nil && firstErr == nil {
-				firstErr = fmt.Errorf("gagal unmap shard %d: %w", i, err)
+	if persist {
+		firstErr = c.flush()
+		if firstErr == nil {
+			if err := c.saveMeta(c.metaState(true)); err != nil {
+				firstErr = fmt.Errorf("save meta: %w", err)
+			} else {
+				c.markDurable(0, atomic.LoadUint64(&c.seq))
 			}
 		}
-		if err := s.file.Close(); err != nil && firstErr == nil {
-			firstErr = fmt.Errorf("gagal menutup shard %d: %w", i, err)
+	}
+	if err := closeShards(c.shards); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	if c.shared != nil {
+		if err := c.shared.close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("close shared header: %w", err)
 		}
 	}
+	if c.lockFile != nil {
+		c.lockFile.Close() // releases the writer lock
+	}
 	return firstErr
 }
@@ -2,4 +2,7 @@ module github.com/luhtfiimanal/go-cache-archive
 
 go 1.24.4
 
-require golang.org/x/sys v0.33.0
+require (
+	github.com/klauspost/compress v1.18.0
+	golang.org/x/sys v0.33.0
+)
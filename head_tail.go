@@ -1,97 +1,175 @@
 package archive
 
 import (
-	"encoding/binary"
 	"fmt"
-	"os"
 	"sync/atomic"
 )
 
-// meta file layout: 16 bytes (little-endian)
-// 0..7  : uint64 head (last written ID)
-// 8..15 : uint64 tail (oldest valid ID, currently informational)
+// metaState snapshots head, tail, seq and the trimmed range for persisting.
+// All of them are derived from one load of seq, so concurrent writers cannot
+// tear the snapshot.
+func (c *RingBufferCache) metaState(clean bool) metaState {
+	m := c.positionAt(atomic.LoadUint64(&c.seq))
+	if m.seq == 0 {
+		m.head, m.tail = atomic.LoadUint64(&c.head), atomic.LoadUint64(&c.tail)
+	}
+	m.clean = clean
+	return m
+}
+
+// setMeta installs head, tail, seq and the trimmed range, deriving seq for
+// meta files written before it was persisted. Everything up to seq counts as
+// committed.
+func (c *RingBufferCache) setMeta(m metaState) {
+	atomic.StoreUint64(&c.head, m.head)
+	atomic.StoreUint64(&c.tail, m.tail)
+	if m.seq == 0 {
+		m.seq = c.deriveSeq()
+	}
+	atomic.StoreUint64(&c.seq, m.seq)
+	atomic.StoreUint64(&c.commitSeq, m.seq)
+	atomic.StoreUint64(&c.trimSeq, min(m.trim, m.seq))
+}
 
-func metaPath(base string) string { return base + ".meta" }
+// oldestAt returns the sequence of the oldest live record of a ring whose
+// write count is seq and whose records up to trim were expired by retention.
+func (c *RingBufferCache) oldestAt(seq, trim uint64) uint64 {
+	oldest := uint64(1)
+	if seq > uint64(c.size) {
+		oldest = seq - uint64(c.size) + 1
+	}
+	return max(oldest, trim+1)
+}
 
-func saveMeta(path string, head, tail uint64) error {
-	buf := make([]byte, 16)
-	binary.LittleEndian.PutUint64(buf[0:8], head)
-	binary.LittleEndian.PutUint64(buf[8:16], tail)
-	return os.WriteFile(path, buf, 0o666)
+// oldestSeq returns the sequence of the oldest record still readable: not
+// overwritten and not expired.
+func (c *RingBufferCache) oldestSeq() uint64 {
+	return c.oldestAt(atomic.LoadUint64(&c.seq), atomic.LoadUint64(&c.trimSeq))
 }
 
-func loadMeta(path string) (head, tail uint64, err error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return 0, 0, err
+// positionAt returns head and tail for a ring whose write count is seq.
+func (c *RingBufferCache) positionAt(seq uint64) metaState {
+	start := uint64(c.minIDAlloc)
+	if start == 0 {
+		start = 1
 	}
-	if len(data) < 16 {
-		return 0, 0, fmt.Errorf("meta file too small")
+	if seq == 0 {
+		return metaState{head: start - 1, tail: start}
 	}
-	head = binary.LittleEndian.Uint64(data[0:8])
-	tail = binary.LittleEndian.Uint64(data[8:16])
-	return head, tail, nil
+	trim := min(atomic.LoadUint64(&c.trimSeq), seq)
+	head := uint64(c.idForSeq(seq))
+	tail := uint64(c.idForSeq(c.oldestAt(seq, trim)))
+	return metaState{head: head, tail: tail, seq: seq, trim: trim}
 }
 
-// Head returns current head (last written ID).
+// deriveSeq estimates the write count from head/tail for meta files written
+// before the sequence number was persisted. A full ring is assumed to have
+// wrapped exactly once.
+func (c *RingBufferCache) deriveSeq() uint64 {
+	head := atomic.LoadUint64(&c.head)
+	min := uint64(c.minIDAlloc)
+	if head < min {
+		return 0
+	}
+	seq := head - min + 1
+	if atomic.LoadUint64(&c.tail) != min {
+		seq += uint64(c.size)
+	}
+	return seq
+}
+
+// Head returns current head: the last ID committed by WriteHead.
 func (c *RingBufferCache) Head() int64 {
+	c.layoutMu.RLock()
+	defer c.layoutMu.RUnlock()
+	c.refreshShared()
+	if seq := atomic.LoadUint64(&c.commitSeq); seq > 0 {
+		return c.idForSeq(seq)
+	}
 	return int64(atomic.LoadUint64(&c.head))
 }
 
-// Tail returns current tail (currently static unless eviction implemented).
+// Tail returns the ID of the oldest readable record: the slot after head once
+// the ring has wrapped, moved further forward by retention (see
+// RetentionPolicy). When retention expired every record Tail is the slot
+// after Head. Tail moves as soon as a writer claims the slot it points at.
 func (c *RingBufferCache) Tail() int64 {
-	return int64(atomic.LoadUint64(&c.tail))
+	c.layoutMu.RLock()
+	defer c.layoutMu.RUnlock()
+	c.refreshShared()
+	if atomic.LoadUint64(&c.seq) == 0 && atomic.LoadUint64(&c.trimSeq) == 0 {
+		return int64(atomic.LoadUint64(&c.tail)) // nothing written yet
+	}
+	return c.idForSeq(c.oldestSeq())
 }
 
 // WriteHead writes payload to the next ID (head+1, wrapping) and returns the new ID.
+//
+// WriteHead is safe for concurrent producers and linearizable: each call
+// claims its slots with a single atomic step, and records become visible to
+// Head(), Subscribe and cursors strictly in claim order, so IDs are never
+// duplicated or skipped.
+//
+// In VariableLength mode a payload spanning several slots advances head by
+// the number of slots used; the returned ID is the first slot of the record.
+// Timestamped caches stamp the record with the current time.
 func (c *RingBufferCache) WriteHead(payload []byte, flush bool) (int64, error) {
-	// only one writer assumed; but keep writerActive flag for readers if needed later
-	atomic.StoreUint32(&c.writerActive, 1)
-	defer atomic.StoreUint32(&c.writerActive, 0)
-
-	nextID := atomic.AddUint64(&c.head, 1)
-	max := c.maxIDAlloc
-	if max == 0 {
-		max = uint64(c.size)
+	if err := c.enterWrite(); err != nil {
+		return 0, err
 	}
-	min := uint64(c.minIDAlloc)
+	defer c.exit()
+	return c.writeHead(payload, flush, c.stamp())
+}
 
-	// wrap detection
-	if nextID > max {
-		// reset to min
-		atomic.StoreUint64(&c.head, min)
-		nextID = min
+// writeHead is WriteHead with an explicit timestamp.
+func (c *RingBufferCache) writeHead(payload []byte, flush bool, ts int64) (int64, error) {
+	payload, err := c.encodeRecord(payload)
+	if err != nil {
+		return 0, err
 	}
-
-	// handle tail tracking
-	oldTail := atomic.LoadUint64(&c.tail)
-	if nextID == min {
-		// we just wrapped
-		if oldTail == min {
-			// first time buffer becomes full, move tail to min+1
-			atomic.StoreUint64(&c.tail, min+1)
-		} else {
-			// subsequent wraps, tail already tracking, move to head+1
-			atomic.StoreUint64(&c.tail, min+1)
-		}
-	} else if oldTail != min {
-		// buffer already full, tail always head+1 modulo
-		tail := nextID + 1
-		if tail > max {
-			tail = min
-		}
-		atomic.StoreUint64(&c.tail, tail)
+	n, err := c.checkPayload(c.sealedLen(len(payload)))
+	if err != nil {
+		return 0, err
 	}
 
-	if err := c.Write(int64(nextID), payload, flush); err != nil {
+	flush = c.wantSync(flush)
+	first, last := c.claim(n)
+	firstID := c.idForSeq(first)
+	err = c.write(firstID, payload, flush, ts)
+	// a failed claim is still committed so later writers are not blocked
+	c.commitHead(first, last)
+	if err != nil {
 		return 0, err
 	}
 
 	// persist meta if flush requested
 	if flush {
-		if err := saveMeta(c.metaPath, atomic.LoadUint64(&c.head), atomic.LoadUint64(&c.tail)); err != nil {
-			return int64(nextID), fmt.Errorf("save meta: %w", err)
+		if err := c.saveMeta(c.metaState(false)); err != nil {
+			return firstID, fmt.Errorf("save meta: %w", err)
+		}
+		c.markDurable(first, last)
+	} else {
+		c.noteUnsynced(1)
+	}
+	return firstID, nil
+}
+
+// claim reserves the next n slots after head and returns the sequence
+// numbers of the first and last. Claiming moves Tail() past the records the
+// slots held; every claim must be followed by commitHead.
+//
+// When more than a ring's worth of slots is in flight, a claim could reach a
+// slot whose previous claimant has not written it yet, so claim waits for
+// that claim to be committed and writes to one slot stay in claim order.
+func (c *RingBufferCache) claim(n int64) (first, last uint64) {
+	last = atomic.AddUint64(&c.seq, uint64(n))
+	c.publishSeq(last)
+	if size := uint64(c.size); last > size && atomic.LoadUint64(&c.commitSeq) < last-size {
+		c.commitMu.Lock()
+		for atomic.LoadUint64(&c.commitSeq) < last-size {
+			c.committed.Wait()
 		}
+		c.commitMu.Unlock()
 	}
-	return int64(nextID), nil
+	return last - uint64(n) + 1, last
 }
@@ -2,6 +2,11 @@ package archive
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
 	"testing"
 )
 
@@ -59,3 +64,124 @@ func TestWriteHeadWrap(t *testing.T) {
 		t.Fatalf("expected head wrap to 3, got %d", cache.Head())
 	}
 }
+
+// TestWriteHeadConcurrentProducers is meant to be run with -race.
+func TestWriteHeadConcurrentProducers(t *testing.T) {
+	const (
+		producers = 8
+		slots     = 4096
+	)
+	opts := DefaultOptions()
+	opts.MaxIDAlloc = slots
+	opts.VariableLength = true
+	opts.Sequenced = true
+	cache, _ := newTestCacheWithOpts(t, slots, 8, opts)
+	defer cache.Close()
+
+	// payloads of 1 or 2 slots, tagged with producer and call number
+	payload := func(p, i int) []byte {
+		b := []byte(fmt.Sprintf("p%d-%04d", p, i))
+		if i%3 == 0 {
+			b = append(b, "+tail"...)
+		}
+		return b
+	}
+	type written struct {
+		id      int64
+		payload []byte
+	}
+	run := func(calls int) [][]written {
+		out := make([][]written, producers)
+		var wg sync.WaitGroup
+		for p := 0; p < producers; p++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < calls; i++ {
+					if i%10 == 9 {
+						batch := [][]byte{payload(p, i), payload(p, i+1)}
+						ids, err := cache.WriteHeadBatch(batch, false)
+						if err != nil {
+							t.Errorf("WriteHeadBatch: %v", err)
+							return
+						}
+						out[p] = append(out[p], written{ids[0], batch[0]}, written{ids[1], batch[1]})
+						i++
+						continue
+					}
+					b := payload(p, i)
+					id, err := cache.WriteHead(b, false)
+					if err != nil {
+						t.Errorf("WriteHead: %v", err)
+						return
+					}
+					out[p] = append(out[p], written{id, b})
+				}
+			}()
+		}
+		wg.Wait()
+		return out
+	}
+
+	// first lap: the claimed slots must tile 1..Seq() without gaps or overlaps
+	var all []written
+	for _, w := range run(200) {
+		all = append(all, w...)
+	}
+	slices.SortFunc(all, func(a, b written) int { return int(a.id - b.id) })
+	next := int64(1)
+	for _, w := range all {
+		if w.id != next {
+			t.Fatalf("id %d claimed, expected %d", w.id, next)
+		}
+		if got, err := cache.Read(w.id); err != nil || !bytes.Equal(got, w.payload) {
+			t.Fatalf("Read(%d) = %q, %v; want %q", w.id, got, err, w.payload)
+		}
+		next += cache.slotsFor(len(w.payload))
+	}
+	if seq := cache.Seq(); uint64(next-1) != seq || cache.Head() != next-1 {
+		t.Fatalf("seq %d head %d, last slot %d", seq, cache.Head(), next-1)
+	}
+
+	// wrapping laps with a subscriber watching: commits arrive gap-free
+	ctx, cancel := context.WithCancel(context.Background())
+	sub, _ := cache.Subscribe(ctx, cache.Head())
+	checked := make(chan error, 1)
+	go func() {
+		var want uint64
+		for r := range sub {
+			switch {
+			case errors.Is(r.Err, ErrLapped):
+				want = 0
+				continue
+			case r.Err != nil:
+				checked <- r.Err
+				return
+			case want != 0 && r.Seq != want:
+				checked <- fmt.Errorf("seq %d delivered, expected %d", r.Seq, want)
+				return
+			}
+			want = r.Seq + uint64(cache.slotsFor(len(r.Payload)))
+		}
+		checked <- nil
+	}()
+	run(1000)
+	cancel()
+	if err := <-checked; err != nil {
+		t.Fatal(err)
+	}
+
+	seq := cache.Seq()
+	if cache.Head() != cache.idForSeq(seq) || cache.Tail() != cache.nextID(cache.Head()) {
+		t.Fatalf("head %d tail %d after %d slots", cache.Head(), cache.Tail(), seq)
+	}
+	// in ring order, every producer's records appear in call order
+	last := make(map[byte]string)
+	for _, b := range cache.All() {
+		p, n := b[1], string(b[3:7])
+		if n <= last[p] {
+			t.Fatalf("producer %c: call %s stored after %s", p, n, last[p])
+		}
+		last[p] = n
+	}
+}
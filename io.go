@@ -1,220 +1,423 @@
 package archive
 
 import (
-	"encoding/binary"
+	"errors"
 	"fmt"
-	"hash/crc32"
+	"os"
+	"sync"
 	"sync/atomic"
 
 	"golang.org/x/sys/unix"
 )
 
-// prefetch mengambil data di sekitar ID yang sedang diakses.
-func (c *RingBufferCache) prefetch(s *shard, relID int64) {
-	if c.options.PrefetchSize <= 0 {
-		return
-	}
-	for i := int64(1); i <= int64(c.options.PrefetchSize); i++ {
-		nextID := relID + i
-		if nextID > s.size {
-			break
-		}
-		id := s.offset + nextID
-		if _, exists := c.prefetchMap.Load(id); exists {
-			continue
-		}
-		c.prefetchMap.Store(id, true)
-		go func(fetchID int64) {
-			c.Read(fetchID)
-			c.prefetchMap.Delete(fetchID) // simple eviction
-		}(id)
-	}
-}
-
-// Write menulis payload ke ID tertentu.
 // translate absolute id to relative (1-based)
 func (c *RingBufferCache) absToRel(id int64) (int64, error) {
 	if id < c.minIDAlloc || id > int64(c.maxIDAlloc) {
-		return 0, fmt.Errorf("id out of range: %d (allowed %d..%d)", id, c.minIDAlloc, c.maxIDAlloc)
+		err := fmt.Errorf("%w: allowed %d..%d", ErrOutOfRange, c.minIDAlloc, c.maxIDAlloc)
+		return 0, &RecordError{ID: id, Shard: -1, Offset: -1, Err: err}
 	}
 	return id - c.minIDAlloc + 1, nil
 }
 
-func (c *RingBufferCache) Write(id int64, payload []byte, flush bool) error {
+// holds reports whether id lies in the ID range of the cache.
+func (c *RingBufferCache) holds(id int64) bool {
+	return id >= c.minIDAlloc && id <= int64(c.maxIDAlloc)
+}
+
+// slotAt resolves an absolute ID to its shard and byte offset within it.
+func (c *RingBufferCache) slotAt(id int64) (*shard, int64, error) {
 	relID, err := c.absToRel(id)
 	if err != nil {
-		return err
+		return nil, 0, err
+	}
+	s, localID, err := c.findShard(relID)
+	if err != nil {
+		return nil, 0, c.recordErr(id, nil, -1, err)
 	}
+	return s, (localID - 1) * int64(c.diskRec), nil
+}
 
-	if len(payload) != c.record {
-		return fmt.Errorf("payload size mismatch: got %d want %d", len(payload), c.record)
+// readSlot copies the raw slot at offset into buf.
+func (s *shard) readSlot(buf []byte, offset int64) error {
+	if s.mmap != nil {
+		copy(buf, s.mmap[offset:offset+int64(len(buf))])
+		return nil
 	}
+	_, err := s.file.ReadAt(buf, offset)
+	return closedErr(err)
+}
 
-	shard, localID, err := c.findShard(relID)
-	if err != nil {
-		return err
+// writeSlot stores buf at offset.
+func (s *shard) writeSlot(buf []byte, offset int64) error {
+	if s.mmap != nil {
+		copy(s.mmap[offset:offset+int64(len(buf))], buf)
+		return nil
 	}
+	_, err := s.file.WriteAt(buf, offset)
+	return closedErr(err)
+}
 
-	m := c.lock(id)
-	m.Lock()
-	defer m.Unlock()
+// sync flushes the shard to disk (msync for mmap, fsync otherwise).
+func (s *shard) sync() error {
+	if s.mmap != nil {
+		if err := unix.Msync(s.mmap, unix.MS_SYNC); err != nil {
+			return &ShardError{Op: "msync", Shard: s.index, Path: s.filePath, Err: err}
+		}
+		return nil
+	}
+	if err := s.file.Sync(); err != nil {
+		return &ShardError{Op: "fsync", Shard: s.index, Path: s.filePath, Err: closedErr(err)}
+	}
+	return nil
+}
 
-	offset := (localID - 1) * int64(c.diskRec)
+// closedErr marks I/O on a closed shard file with ErrClosed.
+func closedErr(err error) error {
+	if errors.Is(err, os.ErrClosed) {
+		return fmt.Errorf("%w: %w", ErrClosed, err)
+	}
+	return err
+}
 
-	buf := c.getBufFromPool()
-	defer c.returnBufToPool(buf)
+// Write menulis payload ke ID tertentu.
+//
+// For fixed-size caches the payload must be exactly RecordSize bytes. In
+// VariableLength mode any length is accepted; payloads longer than RecordSize
+// occupy consecutive slots starting at id.
+func (c *RingBufferCache) Write(id int64, payload []byte, flush bool) error {
+	if err := c.enterWrite(); err != nil {
+		return err
+	}
+	defer c.exit()
+	stored, err := c.encodeRecord(payload)
+	if err != nil {
+		return err
+	}
+	sync := c.wantSync(flush)
+	if err := c.write(id, stored, sync, c.stamp()); err != nil {
+		return err
+	}
+	if !sync {
+		c.noteUnsynced(1)
+	}
+	return nil
+}
 
-	crc := crc32.ChecksumIEEE(payload)
-	binary.LittleEndian.PutUint32(buf[0:4], crc)
-	copy(buf[4:], payload)
+func (c *RingBufferCache) write(id int64, payload []byte, flush bool, ts int64) error {
+	first, firstOff, err := c.slotAt(id)
+	if err != nil {
+		return err
+	}
+	n, err := c.checkPayload(c.sealedLen(len(payload)))
+	if err != nil {
+		return c.recordErr(id, first, firstOff, err)
+	}
+	if payload, err = c.sealRecord(id, payload); err != nil {
+		return err
+	}
 
-	if shard.mmap != nil {
-		copy(shard.mmap[offset:offset+int64(c.diskRec)], buf)
-		if flush {
-			return unix.Msync(shard.mmap, unix.MS_SYNC)
-		}
+	if n == 1 {
+		m := c.lock(id)
+		m.Lock()
+		defer m.Unlock()
 	} else {
-		if _, err := shard.file.WriteAt(buf, offset); err != nil {
+		ids := c.spanIDs(id, n)
+		unlock := c.lockSpan(ids, true)
+		defer unlock()
+	}
+
+	bp := c.getBufFromPool()
+	defer c.returnBufToPool(bp)
+	buf := *bp
+
+	// Write continuation slots first and the first slot last, so the record
+	// only becomes readable once all of its data is in place.
+	var dirty dirtySet
+	for i := n - 1; i >= 0; i-- {
+		slotID := c.advanceID(id, i)
+		shard, offset, err := c.slotAt(slotID)
+		if err != nil {
 			return err
 		}
-		if flush {
-			return shard.file.Sync()
+		from := int(i) * c.record
+		chunk := payload[from:min(from+c.record, len(payload))]
+		h := slotHeader{length: len(payload) - from, seq: c.seqForID(slotID), ts: ts}
+		if i > 0 {
+			h.flags = flagContinuation
 		}
+		end := c.encodeSlot(buf, chunk, h)
+		if err := shard.writeSlot(buf[:end], offset); err != nil {
+			return c.recordErr(slotID, shard, offset, err)
+		}
+		dirty.add(shard, offset, int64(end))
+	}
+
+	if flush {
+		return c.syncDirty(dirty)
 	}
 	return nil
 }
 
 // Read mengambil payload dari ID tertentu.
+//
+// The returned slice holds exactly the bytes that were written; in
+// VariableLength mode records spanning several slots are reassembled.
 func (c *RingBufferCache) Read(id int64) ([]byte, error) {
-	relID, err := c.absToRel(id)
-	if err != nil {
+	if err := c.enter(); err != nil {
 		return nil, err
 	}
+	defer c.exit()
+	_, out, err := c.read(id)
+	return out, err
+}
 
-	shard, localID, err := c.findShard(relID)
+// read returns the payload of the record at id together with the header of
+// its first slot, whose length counts the stored (compressed) bytes.
+func (c *RingBufferCache) read(id int64) (slotHeader, []byte, error) {
+	shard, offset, err := c.slotAt(id)
 	if err != nil {
-		return nil, err
+		return slotHeader{}, nil, err
+	}
+	h, out, err := c.readRecord(shard, offset, id)
+	if err != nil {
+		return h, nil, err
+	}
+	if err := c.checkExpired(id, c.ringSeq(id), h, shard, offset); err != nil {
+		return h, nil, err
 	}
+	c.readAhead(id, h.length)
+	return h, out, nil
+}
 
-	m := c.lock(id)
-	m.RLock()
-	defer m.RUnlock()
+// readAhead schedules prefetching after a record of n stored bytes read at id.
+func (c *RingBufferCache) readAhead(id int64, n int) {
+	if c.prefetcher != nil {
+		// read ahead from the last slot of the record
+		c.prefetcher.schedule(c.advanceID(id, c.slotsFor(n)-1))
+	}
+}
 
-	offset := (localID - 1) * int64(c.diskRec)
+// readRecord reads and verifies the record starting at id, updating hit/miss
+// statistics. The header of the first slot is returned even when the slot
+// turns out to be a continuation slot.
+func (c *RingBufferCache) readRecord(shard *shard, offset int64, id int64) (slotHeader, []byte, error) {
+	bp := c.getBufFromPool()
+	defer c.returnBufToPool(bp)
 
-	buf := c.getBufFromPool()
-	defer c.returnBufToPool(buf)
+	h, data, m, err := c.lockSlot(shard, offset, id, *bp)
+	if err != nil {
+		atomic.AddUint64(&c.statMisses, 1)
+		return h, nil, err
+	}
 
-	if shard.mmap != nil {
-		copy(buf, shard.mmap[offset:offset+int64(c.diskRec)])
+	var out []byte
+	if h.length <= c.record {
+		if c.transformed() {
+			out, err = c.decodeStored(nil, id, data)
+		} else {
+			out = make([]byte, h.length)
+			copy(out, data)
+		}
+		m.RUnlock()
 	} else {
-		if _, err := shard.file.ReadAt(buf, offset); err != nil {
+		m.RUnlock()
+		if h, out, err = c.readSpan(id, *bp, nil); err != nil {
 			atomic.AddUint64(&c.statMisses, 1)
-			return nil, err
+			return h, nil, err
+		}
+		if c.transformed() {
+			out, err = c.decodeStored(nil, id, out)
 		}
 	}
-
-	storedCRC := binary.LittleEndian.Uint32(buf[0:4])
-	payload := buf[4:]
-	if crc32.ChecksumIEEE(payload) != storedCRC {
+	if err != nil {
 		atomic.AddUint64(&c.statMisses, 1)
-		return nil, fmt.Errorf("corrupted: CRC mismatch")
+		return h, nil, c.recordErr(id, shard, offset, err)
 	}
 
 	atomic.AddUint64(&c.statHits, 1)
+	return h, out, nil
+}
 
-	if c.options.PrefetchSize > 0 {
-		go c.prefetch(shard, localID)
+// lockSlot read-locks id and verifies the first slot of its record. The
+// returned data aliases the mapping for mmap shards and buf otherwise; it is
+// only valid until the caller releases the returned lock, which on error is
+// already released.
+func (c *RingBufferCache) lockSlot(shard *shard, offset int64, id int64, buf []byte) (slotHeader, []byte, *sync.RWMutex, error) {
+	m := c.lock(id)
+	m.RLock()
+	slot := buf
+	if shard.mmap != nil {
+		slot = shard.mmap[offset : offset+int64(c.diskRec)]
+	} else if err := shard.readSlot(buf, offset); err != nil {
+		m.RUnlock()
+		return slotHeader{}, nil, nil, c.recordErr(id, shard, offset, err)
 	}
+	h, data, err := c.decodeSlot(slot)
+	switch {
+	case err != nil:
+	case h.flags&flagContinuation != 0:
+		err = errContinuation
+	case h.flags&flagTombstone != 0:
+		err = ErrDeleted
+	}
+	if err != nil {
+		m.RUnlock()
+		return h, nil, nil, c.recordErr(id, shard, offset, err)
+	}
+	return h, data, m, nil
+}
 
-	out := make([]byte, c.record)
-	copy(out, payload)
-	return out, nil
+// readSpan reads a record occupying several slots into dst, which is
+// allocated when too small. All slots of the span are locked together so a
+// concurrent writer cannot tear the record.
+func (c *RingBufferCache) readSpan(id int64, buf, dst []byte) (slotHeader, []byte, error) {
+	for {
+		shard, offset, err := c.slotAt(id)
+		if err != nil {
+			return slotHeader{}, nil, err
+		}
+		m := c.lock(id)
+		m.RLock()
+		err = shard.readSlot(buf, offset)
+		m.RUnlock()
+		if err != nil {
+			return slotHeader{}, nil, c.recordErr(id, shard, offset, err)
+		}
+		h, _, err := c.decodeSlot(buf)
+		if err != nil {
+			return h, nil, c.recordErr(id, shard, offset, err)
+		}
+
+		ids := c.spanIDs(id, c.slotsFor(h.length))
+		unlock := c.lockSpan(ids, false)
+		out, again, err := c.readSpanLocked(ids, h, buf, dst)
+		unlock()
+		if !again {
+			return h, out, err
+		}
+	}
+}
+
+// readSpanLocked reassembles the record described by first from ids into
+// dst. It reports again=true when the first slot changed since the caller
+// sized the span.
+func (c *RingBufferCache) readSpanLocked(ids []int64, first slotHeader, buf, dst []byte) (out []byte, again bool, err error) {
+	out = dst[:0]
+	if cap(dst) < first.length {
+		out = make([]byte, 0, first.length)
+	}
+	for i, slotID := range ids {
+		shard, offset, err := c.slotAt(slotID)
+		if err != nil {
+			return nil, false, err
+		}
+		if err := shard.readSlot(buf, offset); err != nil {
+			return nil, false, c.recordErr(slotID, shard, offset, err)
+		}
+		h, data, err := c.decodeSlot(buf)
+		if err != nil {
+			return nil, false, c.recordErr(slotID, shard, offset, err)
+		}
+		if i == 0 {
+			if h.flags&flagContinuation != 0 {
+				return nil, false, c.recordErr(slotID, shard, offset, errContinuation)
+			}
+			if h.flags&flagTombstone != 0 {
+				return nil, false, c.recordErr(slotID, shard, offset, ErrDeleted)
+			}
+			if h.length != first.length || h.seq != first.seq {
+				return nil, true, nil
+			}
+		} else if h.flags&flagContinuation == 0 || h.length != first.length-len(out) {
+			err := fmt.Errorf("%w: broken record span starting at id %d", ErrCorrupted, ids[0])
+			return nil, false, c.recordErr(slotID, shard, offset, err)
+		}
+		out = append(out, data...)
+	}
+	return out, false, nil
 }
 
 // BulkWrite menulis beberapa payload berturut-turut.
+//
+// In VariableLength mode each payload starts right after the slots used by
+// the previous one. The whole batch is locked once and written with one copy
+// or pwrite per shard run; with flush only the touched range is synced.
 func (c *RingBufferCache) BulkWrite(startID int64, payloads [][]byte, flush bool) error {
+	if err := c.enterWrite(); err != nil {
+		return err
+	}
+	defer c.exit()
 	if startID < 1 || startID+int64(len(payloads))-1 > c.size {
-		return fmt.Errorf("id range out of bounds")
+		return fmt.Errorf("%w: %d records from id %d (cache holds %d)", ErrOutOfRange, len(payloads), startID, c.size)
 	}
-	for i, p := range payloads {
-		if len(p) != c.record {
-			return fmt.Errorf("payload %d must be exactly %d bytes", i, c.record)
-		}
+	payloads, err := c.encodeRecords(payloads)
+	if err != nil {
+		return err
 	}
 	for i, p := range payloads {
-		id := startID + int64(i)
-		shouldFlush := flush && i == len(payloads)-1
-		if err := c.Write(id, p, shouldFlush); err != nil {
-			return fmt.Errorf("gagal menulis record %d: %w", id, err)
+		if _, err := c.checkPayload(c.sealedLen(len(p))); err != nil {
+			return fmt.Errorf("payload %d: %w", i, err)
 		}
 	}
+	if len(payloads) == 0 {
+		return nil
+	}
+	sync := c.wantSync(flush)
+	if err := c.writeRun(startID, payloads, sync, c.stamp()); err != nil {
+		return err
+	}
+	if !sync {
+		c.noteUnsynced(len(payloads))
+	}
 	return nil
 }
 
 // BulkRead membaca beberapa record berturut-turut.
+//
+// count is a number of records; in VariableLength mode a record spanning
+// several slots counts once. Deleted records are skipped, so the result may
+// hold fewer than count payloads.
 func (c *RingBufferCache) BulkRead(startID int64, count int) ([][]byte, error) {
+	if err := c.enter(); err != nil {
+		return nil, err
+	}
+	defer c.exit()
 	if startID < 1 || startID+int64(count)-1 > c.size {
-		return nil, fmt.Errorf("id range out of bounds")
+		return nil, fmt.Errorf("%w: %d records from id %d (cache holds %d)", ErrOutOfRange, count, startID, c.size)
 	}
-	res := make([][]byte, count)
+	res := make([][]byte, 0, count)
+	id := startID
 	for i := 0; i < count; i++ {
-		id := startID + int64(i)
-		p, err := c.Read(id)
+		h, p, err := c.read(id)
+		if errors.Is(err, ErrDeleted) {
+			// tombstones are left out of the result
+			id = c.nextID(id)
+			continue
+		}
 		if err != nil {
-			return res, fmt.Errorf("gagal membaca record %d: %w", id, err)
+			return res, fmt.Errorf("bulk read record %d: %w", i, err)
 		}
-		res[i] = p
+		res = append(res, p)
+		id = c.advanceID(id, c.slotsFor(h.length))
 	}
 	return res, nil
 }
 
-// Delete menghapus record dengan ID tertentu dengan cara menulis payload
-// bernilai nol sepanjang `record` byte serta CRC yang sesuai (agar terbaca
-// sebagai record kosong yang valid). Selalu melakukan flush (fsync/msync)
-// sehingga perubahan segera persisten di disk.
+// Delete menghapus record dengan ID tertentu dengan cara menulis slot
+// tombstone, sehingga Read mengembalikan ErrDeleted dan BulkRead serta
+// iterator melewatinya. Selalu melakukan flush (fsync/msync) sehingga
+// perubahan segera persisten di disk, kecuali dengan DurabilityOS.
 func (c *RingBufferCache) Delete(id int64) error {
-	// Terjemahkan ID absolut ➜ relatif (1-based) serta cek rentang.
-	relID, err := c.absToRel(id)
-	if err != nil {
+	if err := c.enterWrite(); err != nil {
 		return err
 	}
-
-	// Temukan shard dan offset.
-	shard, localID, err := c.findShard(relID)
-	if err != nil {
+	defer c.exit()
+	if _, _, err := c.slotAt(id); err != nil {
 		return err
 	}
-
-	// Ambil mutex eksklusif untuk ID ini agar tidak race dengan pembacaan/penulisan lain.
-	m := c.lock(id)
-	m.Lock()
-	defer m.Unlock()
-
-	// Hitung offset byte di dalam shard.
-	offset := (localID - 1) * int64(c.diskRec)
-
-	// Persiapkan buffer berisi nol + CRC32 yang valid.
-	buf := c.getBufFromPool()
-	defer c.returnBufToPool(buf)
-
-	// Payload kosong (c.record byte semuanya 0)
-	zeroPayload := buf[4:]
-	for i := range zeroPayload {
-		zeroPayload[i] = 0
-	}
-
-	crc := crc32.ChecksumIEEE(zeroPayload)
-	binary.LittleEndian.PutUint32(buf[0:4], crc)
-
-	// Tulis ke backing storage.
-	if shard.mmap != nil {
-		copy(shard.mmap[offset:offset+int64(c.diskRec)], buf)
-		return unix.Msync(shard.mmap, unix.MS_SYNC)
-	}
-
-	if _, err := shard.file.WriteAt(buf, offset); err != nil {
+	dirty, err := c.deleteRun(id, 1, false)
+	if err != nil || c.options.Durability.Mode == DurabilityOS {
 		return err
 	}
-	return shard.file.Sync()
+	return c.syncDirty(dirty)
 }
@@ -0,0 +1,115 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
+
+// A cache has at most one writer: NewRingBufferCacheWithOptions holds an
+// exclusive flock on <base>.lock until Close. The lock dies with the process,
+// so a crashed writer never leaves a stale lock behind. Readers opened with
+// OpenReadOnly take no lock and never write the shards, .meta or .cfg;
+// only cursor commits are persisted.
+
+func lockPath(base string) string { return base + ".lock" }
+
+// lockWriter takes the writer lock of the cache at base.
+func lockWriter(base string) (*os.File, error) {
+	path := lockPath(base)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open lock file: %w", err)
+	}
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, unix.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%w: %s", ErrLocked, path)
+		}
+		return nil, fmt.Errorf("lock %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// openWriter creates the directory of basePath if needed, takes the writer
+// lock and opens the cache for writing.
+func openWriter(basePath string, opts CacheOptions) (*RingBufferCache, error) {
+	if err := os.MkdirAll(filepath.Dir(basePath), 0o755); err != nil {
+		return nil, fmt.Errorf("create directory: %w", err)
+	}
+	lock, err := lockWriter(basePath)
+	if err != nil {
+		return nil, err
+	}
+	cache, err := openCache(basePath, opts, false)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	cache.lockFile = lock
+	return cache, nil
+}
+
+// OpenReadOnly opens the cache at basePath for reading while another process
+// may be writing it. The layout is taken from the .cfg file, so only the
+// runtime fields of opts (UseMmap, PrefetchSize, BufferPoolSize, ...) are
+// used. Shards are opened read-only and, with UseMmap, mapped PROT_READ.
+//
+// Head, Tail and Seq follow the writer live through the shared header file
+// <basePath>.head; when no writer ever created it they stay as recorded in
+// .meta. Every method that would modify the cache returns ErrReadOnly.
+// When the writer resizes the ring, the shard files are reopened on the next
+// operation.
+func OpenReadOnly(basePath string, opts CacheOptions) (*RingBufferCache, error) {
+	for {
+		epoch, err := layoutEpochAt(basePath)
+		if err != nil {
+			return nil, err
+		}
+		c, err := openReadOnly(basePath, opts)
+		if err == nil {
+			atomic.StoreUint32(&c.layoutEpoch, epoch)
+			return c, nil
+		}
+		// files replaced while they were opened: try the new layout
+		if now, nerr := layoutEpochAt(basePath); nerr != nil || now == epoch {
+			return nil, err
+		}
+	}
+}
+
+func openReadOnly(basePath string, opts CacheOptions) (*RingBufferCache, error) {
+	cfg, err := readConfig(basePath + ".cfg")
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.apply(&opts); err != nil {
+		return nil, fmt.Errorf("config %s.cfg: %w", basePath, err)
+	}
+	return openCache(basePath, opts, true)
+}
+
+// checkShardSize verifies that a shard file opened read-only is large
+// enough for the layout.
+func checkShardSize(f *os.File, size int64) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() < size {
+		return fmt.Errorf("%w: file is %d bytes, layout needs %d", ErrCorrupted, fi.Size(), size)
+	}
+	return nil
+}
+
+// enterWrite is enter for operations that modify the cache.
+func (c *RingBufferCache) enterWrite() error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	return c.enter()
+}
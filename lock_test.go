@@ -0,0 +1,101 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestWriterLock(t *testing.T) {
+	cache, base := newTestCache(t, 8, 4)
+	opts := cache.options
+
+	if _, err := NewRingBufferCacheWithOptions(base, opts); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second writer: %v, want ErrLocked", err)
+	}
+	cache.Close()
+	again, err := NewRingBufferCacheWithOptions(base, opts)
+	if err != nil {
+		t.Fatalf("reopen after Close: %v", err)
+	}
+	again.Close()
+}
+
+func TestOpenReadOnlyFollowsWriter(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxIDAlloc = 8
+	opts.Sequenced = true
+	cache, base := newTestCacheWithOpts(t, 8, 4, opts)
+	defer cache.Close()
+	cache.WriteHead([]byte("r001"), true)
+
+	ropts := DefaultOptions()
+	ropts.UseMmap = true
+	ropts.PrefetchSize = 0
+	reader, err := OpenReadOnly(base, ropts)
+	if err != nil {
+		t.Fatalf("OpenReadOnly: %v", err)
+	}
+	if reader.Head() != 1 || reader.Tail() != 1 {
+		t.Fatalf("reader head=%d tail=%d", reader.Head(), reader.Tail())
+	}
+
+	// no flush: the reader sees the writer's position through .head
+	for i := 2; i <= 10; i++ {
+		cache.WriteHead([]byte(fmt.Sprintf("r%03d", i)), false)
+	}
+	if reader.Head() != 2 || reader.Tail() != 3 || reader.Seq() != 10 {
+		t.Fatalf("reader head=%d tail=%d seq=%d", reader.Head(), reader.Tail(), reader.Seq())
+	}
+	if got, err := reader.Read(2); err != nil || string(got) != "r010" {
+		t.Fatalf("reader Read(2) = %q, %v", got, err)
+	}
+	if got, err := reader.ReadSeq(9); err != nil || string(got) != "r009" {
+		t.Fatalf("reader ReadSeq(9) = %q, %v", got, err)
+	}
+	if ids, _ := collect(reader.All()); len(ids) != 8 || ids[0] != 3 {
+		t.Fatalf("reader All = %v", ids)
+	}
+
+	if _, err := reader.WriteHead([]byte("nope"), false); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("WriteHead on reader: %v", err)
+	}
+	if err := reader.Write(1, []byte("nope"), false); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("Write on reader: %v", err)
+	}
+	if err := reader.Delete(1); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("Delete on reader: %v", err)
+	}
+
+	meta, _ := os.ReadFile(metaPath(base))
+	if err := reader.Close(); err != nil {
+		t.Fatalf("reader Close: %v", err)
+	}
+	if after, _ := os.ReadFile(metaPath(base)); string(after) != string(meta) {
+		t.Fatalf("reader Close rewrote .meta")
+	}
+	if _, err := cache.WriteHead([]byte("r011"), false); err != nil {
+		t.Fatalf("writer after reader Close: %v", err)
+	}
+}
+
+func TestOpenReadOnlyWithoutHeader(t *testing.T) {
+	cache, base := newTestCache(t, 8, 4)
+	cache.WriteHead([]byte("r001"), false)
+	cache.WriteHead([]byte("r002"), false)
+	cache.Close()
+	os.Remove(sharedPath(base)) // written by an older version
+
+	reader, err := OpenReadOnly(base, DefaultOptions())
+	if err != nil {
+		t.Fatalf("OpenReadOnly: %v", err)
+	}
+	defer reader.Close()
+	if reader.Head() != 2 || reader.Tail() != 1 {
+		t.Fatalf("head=%d tail=%d from .meta", reader.Head(), reader.Tail())
+	}
+	if _, err := OpenReadOnly(base+".missing", DefaultOptions()); err == nil {
+		t.Fatalf("OpenReadOnly of a missing cache succeeded")
+	}
+}
@@ -0,0 +1,150 @@
+package archive
+
+import (
+	"encoding/binary"
+	"errors"
+	"slices"
+)
+
+// LZ4 block format (no frame): a sequence of
+//
+//	token | [literal length bytes] | literals | offset (2 bytes LE) | [match length bytes]
+//
+// where the token holds the literal length in its high nibble and the match
+// length minus 4 in its low nibble; a nibble of 15 is continued by bytes of
+// 255 and a final byte below 255. The last sequence has literals only. Per
+// the format, the last 5 bytes are always literals and no match starts in
+// the last 12 bytes.
+
+const (
+	lz4MinMatch     = 4
+	lz4LastLiterals = 5
+	lz4MFLimit      = 12
+	lz4HashLog      = 12
+	lz4MaxOffset    = 1<<16 - 1
+)
+
+var errLZ4 = errors.New("malformed lz4 block")
+
+func lz4Hash(v uint32) uint32 { return v * 2654435761 >> (32 - lz4HashLog) }
+
+// lz4Compress appends the LZ4 block encoding of src to dst, using a greedy
+// single-entry hash table.
+func lz4Compress(dst, src []byte) []byte {
+	var table [1 << lz4HashLog]int32 // position+1 of the last occurrence
+	anchor := 0
+	for i := 0; i+lz4MFLimit <= len(src); {
+		v := binary.LittleEndian.Uint32(src[i:])
+		h := lz4Hash(v)
+		ref := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if ref < 0 || i-ref > lz4MaxOffset || binary.LittleEndian.Uint32(src[ref:]) != v {
+			i++
+			continue
+		}
+		n := lz4MinMatch
+		for end := len(src) - lz4LastLiterals; i+n < end && src[ref+n] == src[i+n]; n++ {
+		}
+		dst = lz4Sequence(dst, src[anchor:i], i-ref, n)
+		i += n
+		anchor = i
+	}
+	return lz4Sequence(dst, src[anchor:], 0, 0)
+}
+
+// lz4Sequence appends one sequence; match length 0 ends the block.
+func lz4Sequence(dst, lit []byte, offset, match int) []byte {
+	token := byte(min(len(lit), 15)) << 4
+	if match > 0 {
+		token |= byte(min(match-lz4MinMatch, 15))
+	}
+	dst = append(dst, token)
+	if len(lit) >= 15 {
+		dst = lz4AppendLen(dst, len(lit)-15)
+	}
+	dst = append(dst, lit...)
+	if match == 0 {
+		return dst
+	}
+	dst = append(dst, byte(offset), byte(offset>>8))
+	if match-lz4MinMatch >= 15 {
+		dst = lz4AppendLen(dst, match-lz4MinMatch-15)
+	}
+	return dst
+}
+
+func lz4AppendLen(dst []byte, n int) []byte {
+	for ; n >= 255; n -= 255 {
+		dst = append(dst, 255)
+	}
+	return append(dst, byte(n))
+}
+
+// lz4Decompress appends the n bytes encoded in the LZ4 block src to dst.
+func lz4Decompress(dst, src []byte, n int) ([]byte, error) {
+	start := len(dst)
+	dst = slices.Grow(dst, n)
+	for i := 0; ; {
+		if i >= len(src) {
+			return nil, errLZ4
+		}
+		token := src[i]
+		i++
+		lit := int(token >> 4)
+		if lit == 15 {
+			var ok bool
+			if lit, i, ok = lz4ReadLen(src, i, lit); !ok {
+				return nil, errLZ4
+			}
+		}
+		if lit > len(src)-i || lit > n-(len(dst)-start) {
+			return nil, errLZ4
+		}
+		dst = append(dst, src[i:i+lit]...)
+		i += lit
+		if i == len(src) {
+			return dst, nil // last sequence
+		}
+
+		if len(src)-i < 2 {
+			return nil, errLZ4
+		}
+		offset := int(src[i]) | int(src[i+1])<<8
+		i += 2
+		match := int(token & 15)
+		if match == 15 {
+			var ok bool
+			if match, i, ok = lz4ReadLen(src, i, match); !ok {
+				return nil, errLZ4
+			}
+		}
+		match += lz4MinMatch
+		pos := len(dst) - offset
+		if offset == 0 || pos < start || match > n-(len(dst)-start) {
+			return nil, errLZ4
+		}
+		if offset >= match {
+			dst = append(dst, dst[pos:pos+match]...)
+			continue
+		}
+		for k := 0; k < match; k++ { // overlapping copy repeats the pattern
+			dst = append(dst, dst[pos+k])
+		}
+	}
+}
+
+// lz4ReadLen adds the length continuation bytes at src[i:] to n.
+func lz4ReadLen(src []byte, i, n int) (int, int, bool) {
+	for i < len(src) {
+		b := src[i]
+		i++
+		n += int(b)
+		if b != 255 {
+			return n, i, true
+		}
+		if n > infoLenMask {
+			break
+		}
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,214 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+)
+
+// meta file layout (version 3): 60 bytes (little-endian)
+// 0..3   : magic "RBCM"
+// 4..7   : uint32 version
+// 8..15  : uint64 generation (incremented on every write)
+// 16..23 : uint64 head (last written ID)
+// 24..31 : uint64 tail (oldest valid ID)
+// 32..39 : uint64 seq  (logical write count)
+// 40..47 : uint64 flags (bit 0 = written by a clean Close)
+// 48..55 : uint64 trim (records up to this seq were expired by retention)
+// 56..59 : uint32 CRC32 over all preceding bytes
+//
+// Version 2 files are identical without the trim field (52 bytes).
+//
+// Two copies alternate by generation: even generations go to <base>.meta,
+// odd ones to <base>.meta.alt. Each copy is written to a temp file, fsynced,
+// renamed into place and the directory fsynced, so a crash leaves at least
+// one intact copy; loadMeta picks the newest copy whose checksum verifies.
+//
+// Files without the magic are the original unversioned layout: head, tail
+// and optionally seq and flags as plain uint64 values.
+
+const (
+	metaMagic     = "RBCM"
+	metaVersion   = 3
+	metaSize      = 60
+	metaV2Size    = 52
+	metaFlagClean = 1 << 0
+)
+
+// metaState is the content of the .meta side-car.
+type metaState struct {
+	head  uint64
+	tail  uint64
+	seq   uint64
+	trim  uint64 // last seq expired by retention (0 = none)
+	clean bool
+}
+
+func metaPath(base string) string { return base + ".meta" }
+
+// metaAltPath returns the path of the second meta copy.
+func metaAltPath(path string) string { return path + ".alt" }
+
+func encodeMeta(m metaState, gen uint64) []byte {
+	buf := make([]byte, metaSize)
+	copy(buf[0:4], metaMagic)
+	binary.LittleEndian.PutUint32(buf[4:8], metaVersion)
+	binary.LittleEndian.PutUint64(buf[8:16], gen)
+	binary.LittleEndian.PutUint64(buf[16:24], m.head)
+	binary.LittleEndian.PutUint64(buf[24:32], m.tail)
+	binary.LittleEndian.PutUint64(buf[32:40], m.seq)
+	if m.clean {
+		binary.LittleEndian.PutUint64(buf[40:48], metaFlagClean)
+	}
+	binary.LittleEndian.PutUint64(buf[48:56], m.trim)
+	binary.LittleEndian.PutUint32(buf[56:60], crc32.ChecksumIEEE(buf[:56]))
+	return buf
+}
+
+func decodeMeta(data []byte) (metaState, uint64, error) {
+	var m metaState
+	if !bytes.HasPrefix(data, []byte(metaMagic)) {
+		// unversioned layout
+		if len(data) < 16 {
+			return m, 0, fmt.Errorf("meta file too small")
+		}
+		m.head = binary.LittleEndian.Uint64(data[0:8])
+		m.tail = binary.LittleEndian.Uint64(data[8:16])
+		if len(data) >= 24 {
+			m.seq = binary.LittleEndian.Uint64(data[16:24])
+		}
+		if len(data) >= 32 {
+			m.clean = binary.LittleEndian.Uint64(data[24:32])&metaFlagClean != 0
+		}
+		return m, 0, nil
+	}
+
+	if len(data) < 8 {
+		return m, 0, fmt.Errorf("meta file too small")
+	}
+	size := metaSize
+	switch v := binary.LittleEndian.Uint32(data[4:8]); v {
+	case metaVersion:
+	case 2:
+		size = metaV2Size
+	default:
+		return m, 0, fmt.Errorf("unsupported meta version %d", v)
+	}
+	if len(data) < size {
+		return m, 0, fmt.Errorf("meta file too small")
+	}
+	if crc32.ChecksumIEEE(data[:size-4]) != binary.LittleEndian.Uint32(data[size-4:size]) {
+		return m, 0, fmt.Errorf("meta checksum mismatch")
+	}
+	if size == metaSize {
+		m.trim = binary.LittleEndian.Uint64(data[48:56])
+	}
+	gen := binary.LittleEndian.Uint64(data[8:16])
+	m.head = binary.LittleEndian.Uint64(data[16:24])
+	m.tail = binary.LittleEndian.Uint64(data[24:32])
+	m.seq = binary.LittleEndian.Uint64(data[32:40])
+	m.clean = binary.LittleEndian.Uint64(data[40:48])&metaFlagClean != 0
+	return m, gen, nil
+}
+
+// loadMeta reads both meta copies and returns the newest valid one together
+// with its generation. The error is only non-nil when neither copy is usable.
+func loadMeta(path string) (metaState, uint64, error) {
+	var (
+		best    metaState
+		bestGen uint64
+		found   bool
+		errs    []error
+	)
+	for _, p := range []string{path, metaAltPath(path)} {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		m, gen, err := decodeMeta(data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", filepath.Base(p), err))
+			continue
+		}
+		if !found || gen > bestGen {
+			best, bestGen, found = m, gen, true
+		}
+	}
+	if !found {
+		if len(errs) == 0 {
+			return metaState{}, 0, os.ErrNotExist
+		}
+		return metaState{}, 0, errors.Join(errs...)
+	}
+	return best, bestGen, nil
+}
+
+// saveMeta durably writes m to the meta copy that is not holding the latest
+// generation.
+func (c *RingBufferCache) saveMeta(m metaState) error {
+	c.metaMu.Lock()
+	defer c.metaMu.Unlock()
+
+	gen := c.metaGen + 1
+	path := c.metaPath
+	if gen%2 == 1 {
+		path = metaAltPath(path)
+	}
+	if err := writeFileAtomic(path, encodeMeta(m, gen)); err != nil {
+		return err
+	}
+	c.metaGen = gen
+	return nil
+}
+
+// writeFileAtomic replaces path with data: it writes a temp file in the same
+// directory, fsyncs it, renames it over path and fsyncs the directory.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir fsyncs a directory so a rename inside it is durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,65 @@
+package archive
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMetaFallsBackToOtherCopy(t *testing.T) {
+	cache, base := newTestCache(t, 10, 2)
+	cache.WriteHead([]byte{1, 1}, true)
+	cache.WriteHead([]byte{2, 2}, true)
+	if err := cache.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// the newest copy is torn: the older one must be used instead
+	m, gen, err := loadMeta(metaPath(base))
+	if err != nil || !m.clean || m.head != 2 {
+		t.Fatalf("loadMeta: %+v gen=%d err=%v", m, gen, err)
+	}
+	newest := metaPath(base)
+	if gen%2 == 1 {
+		newest = metaAltPath(newest)
+	}
+	data, _ := os.ReadFile(newest)
+	if err := os.WriteFile(newest, data[:20], 0o644); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	m, older, err := loadMeta(metaPath(base))
+	if err != nil || older != gen-1 || m.head != 2 {
+		t.Fatalf("expected fallback to generation %d, got %+v gen=%d err=%v", gen-1, m, older, err)
+	}
+
+	// a flipped bit is caught by the checksum
+	data[20] ^= 0xFF
+	os.WriteFile(newest, data, 0o644)
+	if _, g, err := loadMeta(metaPath(base)); err != nil || g != gen-1 {
+		t.Fatalf("expected checksum fallback, gen=%d err=%v", g, err)
+	}
+
+	reopened := reopenTestCache(t, base, 2)
+	defer reopened.Close()
+	if reopened.Head() != 2 {
+		t.Fatalf("expected head 2 after fallback, got %d", reopened.Head())
+	}
+}
+
+func TestMetaReadsUnversionedLayout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.data.meta")
+	buf := make([]byte, 16)
+	binary.LittleEndian.PutUint64(buf[0:8], 7)
+	binary.LittleEndian.PutUint64(buf[8:16], 1)
+	if err := os.WriteFile(path, buf, 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	m, gen, err := loadMeta(path)
+	if err != nil || gen != 0 || m.head != 7 || m.tail != 1 || m.clean {
+		t.Fatalf("unexpected legacy meta %+v gen=%d err=%v", m, gen, err)
+	}
+	if _, err := os.Stat(metaAltPath(path)); !os.IsNotExist(err) {
+		t.Fatalf("alt copy should not exist yet")
+	}
+}
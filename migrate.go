@@ -0,0 +1,484 @@
+package archive
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// Migration rebuilds a cache next to the original (at <base>.migrate) and
+// then swaps the files into place. The swap is driven by a plan file,
+// <base>.migrating, written atomically before the first rename and removed
+// after the last one; the constructor replays a leftover plan, so a crash at
+// any point leaves either the old or the new cache, never a mix.
+
+// swapPlan lists the renames and removals that replace one cache with another.
+// The .cfg rename always comes last.
+type swapPlan struct {
+	Renames []swapRename `json:"renames"`
+	Removes []string     `json:"removes"`
+}
+
+type swapRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func swapPlanPath(base string) string { return base + ".migrating" }
+
+// planSwap builds the plan replacing the cache at base, which has oldShards
+// shards, with the one at tmp. Only files that exist now are renamed; stale
+// files of the old cache that nothing replaces are removed.
+func planSwap(base string, oldShards int, tmp string, newShards int) swapPlan {
+	var p swapPlan
+	replaced := make(map[string]bool)
+	rename := func(from, to string) {
+		if _, err := os.Stat(from); err == nil {
+			p.Renames = append(p.Renames, swapRename{From: from, To: to})
+			replaced[to] = true
+		}
+	}
+	for i := 0; i < newShards; i++ {
+		rename(shardPath(tmp, newShards, i), shardPath(base, newShards, i))
+	}
+	rename(metaPath(tmp), metaPath(base))
+	rename(metaAltPath(metaPath(tmp)), metaAltPath(metaPath(base)))
+	rename(genPath(tmp), genPath(base))
+	if names, err := cursorNames(tmp); err == nil {
+		for _, name := range names {
+			rename(cursorPath(tmp, name), cursorPath(base, name))
+		}
+	}
+	rename(tmp+".cfg", base+".cfg")
+
+	stale := []string{metaPath(base), metaAltPath(metaPath(base)), genPath(base)}
+	for i := 0; i < oldShards; i++ {
+		stale = append(stale, shardPath(base, oldShards, i))
+	}
+	for _, path := range stale {
+		if !replaced[path] {
+			p.Removes = append(p.Removes, path)
+		}
+	}
+	return p
+}
+
+// commitSwap persists the plan and applies it.
+func commitSwap(base string, p swapPlan) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(swapPlanPath(base), data); err != nil {
+		return fmt.Errorf("write swap plan: %w", err)
+	}
+	return resumeSwap(base)
+}
+
+// resumeSwap applies a pending swap plan for base, if any. Every step is
+// idempotent so an interrupted swap can be replayed any number of times.
+func resumeSwap(base string) error {
+	path := swapPlanPath(base)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var p swapPlan
+	if err := json.Unmarshal(data, &p); err != nil {
+		return fmt.Errorf("decode swap plan: %w", err)
+	}
+
+	for _, r := range p.Renames {
+		if _, err := os.Stat(r.From); os.IsNotExist(err) {
+			continue // already renamed
+		}
+		if err := os.Rename(r.From, r.To); err != nil {
+			return err
+		}
+	}
+	for _, f := range p.Removes {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := syncDir(filepath.Dir(base)); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(base))
+}
+
+// removeCacheFiles deletes the files of the cache at base, whatever its
+// layout: the shards, the files next to them and the cursor files. Used to
+// clear a half-built migration target.
+func removeCacheFiles(base string) error {
+	paths := []string{base, base + ".cfg", metaPath(base), metaAltPath(metaPath(base)),
+		sharedPath(base), genPath(base)}
+	for i := 0; ; i++ {
+		path := shardPath(base, i+2, i) // <base>.<i>, numbered from 0 without gaps
+		if _, err := os.Lstat(path); err != nil {
+			break
+		}
+		paths = append(paths, path)
+	}
+	names, err := cursorNames(base)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		paths = append(paths, cursorPath(base, name))
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrate rebuilds the cache at basePath with the layout requested by opts.
+// Every record is re-encoded at its own ID; the cache is left as it was when
+// one no longer fits there.
+func migrate(basePath string, opts CacheOptions) error {
+	srcOpts := opts
+	srcOpts.ConfigPolicy = ConfigAdopt
+	if have, err := readConfig(basePath + ".cfg"); err == nil && have.Cipher == "" {
+		srcOpts.Cipher = nil // encrypting a plaintext cache
+	}
+	src, err := openCache(basePath, srcOpts, false)
+	if err != nil {
+		return err
+	}
+	plan, err := buildMigration(src, basePath, opts, false)
+	if cerr := src.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return installLayout(basePath, plan)
+}
+
+// buildMigration copies the records of src, the open cache at basePath,
+// into a new cache with opts at <basePath>.migrate and returns the plan that
+// swaps it into place. The cursor files of basePath are rewritten for the
+// new sequence numbers next to it and swapped in with the rest. With
+// reassign set, records that cannot keep their ID are moved to free slots;
+// otherwise they fail the migration (see relocation).
+func buildMigration(src *RingBufferCache, basePath string, opts CacheOptions, reassign bool) (swapPlan, error) {
+	tmp := basePath + ".migrate"
+	if err := removeCacheFiles(tmp); err != nil {
+		return swapPlan{}, err
+	}
+	cursors, err := loadCursors(basePath)
+	if err != nil {
+		return swapPlan{}, err
+	}
+	dstOpts := opts
+	dstOpts.ConfigPolicy = ConfigStrict
+	dst, err := openCache(tmp, dstOpts, false)
+	if err != nil {
+		return swapPlan{}, err
+	}
+
+	if dst.gens != nil {
+		// the source may have been sealed with the same keys
+		var v uint64
+		if v, err = readGeneration(genPath(basePath)); err == nil {
+			err = dst.gens.seed(v)
+		}
+	}
+	r := relocation{src: src, dst: dst, reassign: reassign, cursors: cursors}
+	if err == nil {
+		err = r.run()
+	}
+	for name, seq := range r.moved {
+		if err != nil {
+			break
+		}
+		var id int64
+		if seq > 0 {
+			id = dst.idForSeq(seq)
+		}
+		err = saveCursor(cursorPath(tmp, name), seq, id)
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		removeCacheFiles(tmp)
+		return swapPlan{}, err
+	}
+	if err := os.Remove(sharedPath(tmp)); err != nil {
+		return swapPlan{}, err
+	}
+	return planSwap(basePath, len(src.shards), tmp, len(dst.shards)), nil
+}
+
+// installLayout applies plan while the layout epoch in the shared header is
+// odd, so read-only processes wait for every file to be in place before they
+// reopen the cache.
+func installLayout(basePath string, plan swapPlan) error {
+	h, err := mapShared(sharedPath(basePath), true)
+	if err != nil {
+		return fmt.Errorf("shared header: %w", err)
+	}
+	defer h.close()
+	epoch := h.word32(sharedEpoch)
+	atomic.AddUint32(epoch, 1)
+	if err := commitSwap(basePath, plan); err != nil {
+		return err // stays odd until the next writer open finishes the swap
+	}
+	atomic.AddUint32(epoch, 1)
+	return nil
+}
+
+// relocation moves the records of src into dst, a new and empty cache.
+//
+// Records are visited newest first in ring order, starting at the head of
+// src, and laid out backwards from the head of dst. A record keeps its ID
+// when its slots are still free there; the slots it skips become tombstones,
+// so the window of dst has no holes. A record that cannot keep its ID is
+// moved to the next free slots when reassign is set and fails the migration
+// otherwise. Once dst is full the older records are dropped. A cache that
+// WriteHead never wrote has no ring order; there the records whose ID lies
+// in the new range are placed first.
+//
+// dst continues the numbering of src from the first sequence that puts the
+// newest record at its ID, so sequence numbers change when the head moves;
+// the committed cursor positions are translated along.
+type relocation struct {
+	src, dst *RingBufferCache
+	reassign bool
+	seq      uint64            // write count of src
+	start    int64             // src ID the walk starts at
+	now      int64             // stamp for records of src that carry none
+	cursors  map[string]uint64 // committed sequence of each cursor in src
+	moved    map[string]uint64 // the same positions in dst
+}
+
+// movedRecord is a record of src on its way to dst.
+type movedRecord struct {
+	id      int64  // src ID of the first slot
+	seq     uint64 // src sequence of the first slot (0 = never claimed)
+	ts      int64
+	deleted bool   // a tombstone
+	stored  []byte // payload encoded for dst
+	slots   int64  // slots taken in dst
+}
+
+// run copies the records and sets the position of dst.
+func (r *relocation) run() error {
+	src, dst := r.src, r.dst
+	r.seq = atomic.LoadUint64(&src.commitSeq)
+	r.start = int64(src.maxIDAlloc)
+	if r.seq > 0 {
+		r.start = src.idForSeq(r.seq)
+	}
+	r.now = dst.stamp()
+
+	head := int64(dst.maxIDAlloc)
+	if r.seq > 0 {
+		// the newest record that keeps its ID fixes the head, leaving room
+		// after it for the newer records that move
+		var newer int64
+		err := r.each(func(m movedRecord) (bool, error) {
+			if !dst.holds(m.id) {
+				if !m.deleted {
+					newer += m.slots
+				}
+				return newer < dst.size, nil
+			}
+			if newer+m.slots <= dst.size {
+				head = dst.advanceID(m.id, m.slots-1+newer)
+			}
+			return false, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	used, err := r.layout(head, nil)
+	if err != nil {
+		return err
+	}
+	if r.seq > 0 {
+		size := uint64(dst.size)
+		seq := max(r.seq, uint64(used))
+		seq += (uint64(head-dst.minIDAlloc) + size - (seq-1)%size) % size
+		atomic.StoreUint64(&dst.trimSeq, seq-uint64(used))
+		dst.setMeta(dst.positionAt(seq))
+		r.moved = make(map[string]uint64, len(r.cursors))
+	}
+	if _, err := r.layout(head, r.put); err != nil {
+		return err
+	}
+	for name, seq := range r.cursors {
+		if _, ok := r.moved[name]; !ok && r.moved != nil && seq > 0 {
+			// committed before every record that was kept
+			r.moved[name] = atomic.LoadUint64(&dst.trimSeq)
+		}
+	}
+	return nil
+}
+
+// each calls fn for every record of src in walk order until fn returns
+// false.
+func (r *relocation) each(fn func(movedRecord) (bool, error)) error {
+	src := r.src
+	visit := func(id int64) (bool, error) {
+		m, ok, err := r.read(id)
+		if err != nil || !ok {
+			return err == nil, err
+		}
+		return fn(m)
+	}
+	if r.seq > 0 {
+		for k := int64(0); k < src.size; k++ {
+			if more, err := visit(src.advanceID(r.start, src.size-k)); !more || err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, inside := range []bool{true, false} {
+		for id := int64(src.maxIDAlloc); id >= src.minIDAlloc; id-- {
+			if r.dst.holds(id) != inside {
+				continue
+			}
+			if more, err := visit(id); !more || err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// read returns the record whose first slot is at id. ok is false for slots
+// that start no record: continuation slots, unwritten or corrupted slots
+// and expired records.
+func (r *relocation) read(id int64) (movedRecord, bool, error) {
+	src, dst := r.src, r.dst
+	s, off, err := src.slotAt(id)
+	if err != nil {
+		return movedRecord{}, false, err
+	}
+	m := movedRecord{id: id, seq: src.ringSeq(id), slots: 1}
+	h, payload, err := src.readRecord(s, off, id)
+	if err == nil || errors.Is(err, ErrDeleted) {
+		m.deleted = err != nil
+		err = src.checkExpired(id, m.seq, h, s, off)
+	}
+	switch {
+	case err == nil:
+	case errors.Is(err, errContinuation), errors.Is(err, ErrCorrupted), errors.Is(err, ErrExpired):
+		return m, false, nil
+	default:
+		return m, false, err
+	}
+	m.ts = h.ts
+	if m.ts == 0 {
+		m.ts = r.now
+	}
+	if m.deleted {
+		return m, true, nil
+	}
+	if m.stored, err = dst.encodeRecord(payload); err == nil {
+		m.slots, err = dst.checkPayload(dst.sealedLen(len(m.stored)))
+	}
+	if errors.Is(err, ErrPayloadSize) && r.reassign {
+		return m, false, nil // larger than the new ring
+	}
+	if err != nil {
+		return m, false, src.recordErr(id, s, off, err)
+	}
+	return m, true, nil
+}
+
+// layout places every record of src backwards from head and calls put, if
+// set, for each one. It returns how many slots of dst the records span,
+// skipped slots included.
+func (r *relocation) layout(head int64, put func(m movedRecord, to, gap int64) error) (int64, error) {
+	dst := r.dst
+	next, free := head, dst.size // newest free slot and free slots left
+	err := r.each(func(m movedRecord) (bool, error) {
+		var to, gap int64
+		kept := false
+		if dst.holds(m.id) {
+			// slots between next and the end of the record stay empty
+			gap = (next - dst.advanceID(m.id, m.slots-1) + dst.size) % dst.size
+			to, kept = m.id, gap+m.slots <= free
+		}
+		switch {
+		case kept:
+		case m.deleted:
+			return true, nil // a tombstone only matters at its ID
+		case !r.reassign:
+			return false, fmt.Errorf("id %d does not fit at the same ID in the new layout", m.id)
+		case m.slots > free:
+			return false, nil // dst is full: the older records are dropped
+		default:
+			to, gap = dst.advanceID(next, dst.size-m.slots+1), 0
+		}
+		free -= gap + m.slots
+		next = dst.advanceID(to, dst.size-1)
+		if put == nil {
+			return true, nil
+		}
+		return true, put(m, to, gap)
+	})
+	return dst.size - free, err
+}
+
+// put writes m at to, after the gap slots that follow it.
+func (r *relocation) put(m movedRecord, to, gap int64) error {
+	dst := r.dst
+	last := dst.advanceID(to, m.slots-1)
+	for k := int64(1); k <= gap && r.seq > 0; k++ {
+		// the window of dst stays readable and ordered by time
+		if err := r.tombstone(dst.advanceID(last, k), m.ts); err != nil {
+			return err
+		}
+	}
+	if m.deleted {
+		return r.tombstone(to, m.ts)
+	}
+	if err := dst.write(to, m.stored, false, m.ts); err != nil {
+		return err
+	}
+	if m.seq == 0 {
+		return nil
+	}
+	for name, seq := range r.cursors {
+		if _, ok := r.moved[name]; !ok && m.seq <= seq {
+			// the newest record the cursor had committed
+			r.moved[name] = dst.ringSeq(to)
+		}
+	}
+	return nil
+}
+
+// tombstone marks the slot at id of dst as deleted.
+func (r *relocation) tombstone(id, ts int64) error {
+	dst := r.dst
+	s, off, err := dst.slotAt(id)
+	if err != nil {
+		return err
+	}
+	h := slotHeader{flags: flagTombstone, seq: dst.seqForID(id), ts: ts}
+	if !dst.layout.hasInfo {
+		h.length = dst.record
+	}
+	buf := make([]byte, dst.diskRec)
+	hdr := dst.layout.hdrSize
+	dst.encodeSlot(buf, buf[hdr:hdr+h.length], h)
+	return dst.recordErr(id, s, off, s.writeSlot(buf, off))
+}
@@ -0,0 +1,120 @@
+package archive
+
+import (
+	"context"
+	"math"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Commits of a writer reach read-only caches in other processes through the
+// notify word of the shared header: the writer bumps it on every commit and
+// issues FUTEX_WAKE when readers are registered as waiters. The futex is not
+// private, so the kernel matches waiters by file page, not address space.
+
+const (
+	futexWait = 0 // FUTEX_WAIT
+	futexWake = 1 // FUTEX_WAKE
+
+	// sharedPoll bounds a futex wait for readers that cannot register as a
+	// waiter, because the writer does not wake them.
+	sharedPoll = 10 * time.Millisecond
+)
+
+// futexSleep waits until *addr differs from val, a wake-up arrives or the
+// timeout (if positive) elapses.
+func futexSleep(addr *uint32, val uint32, timeout time.Duration) {
+	var ts *unix.Timespec
+	if timeout > 0 {
+		t := unix.NsecToTimespec(int64(timeout))
+		ts = &t
+	}
+	unix.Syscall6(unix.SYS_FUTEX, uintptr(unsafe.Pointer(addr)), futexWait, uintptr(val), uintptr(unsafe.Pointer(ts)), 0, 0)
+}
+
+// futexWakeAll wakes every waiter on addr, in any process.
+func futexWakeAll(addr *uint32) {
+	unix.Syscall6(unix.SYS_FUTEX, uintptr(unsafe.Pointer(addr)), futexWake, math.MaxInt32, 0, 0, 0)
+}
+
+// WaitForHead blocks until a record is committed after the record at ID
+// after and returns the new Head(). Pass Head() to wait for the next write;
+// any value below MinIDAlloc waits for the first record ever written. It
+// returns at once when such a record already exists.
+//
+// In a cache opened with OpenReadOnly it wakes on commits of the writer
+// process. It returns ctx.Err() when ctx ends first and ErrClosed when the
+// cache is closed.
+func (c *RingBufferCache) WaitForHead(ctx context.Context, after int64) (int64, error) {
+	if c.Closed() {
+		return 0, ErrClosed
+	}
+	next, err := c.seqAfter(after)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		wait := c.headChanged()
+		c.refreshShared()
+		if atomic.LoadUint64(&c.commitSeq) >= next {
+			return c.Head(), nil
+		}
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-c.done:
+			return 0, ErrClosed
+		}
+	}
+}
+
+// watchShared runs in a read-only cache once something waits on
+// headChanged. It sleeps on the shared notify word and turns each commit of
+// the writer into an in-process head notification, until Close.
+func (c *RingBufferCache) watchShared() {
+	defer close(c.watchDone)
+	h := c.shared
+	notify := h.word32(sharedNotify)
+	timeout := sharedPoll
+	if h.writable {
+		waiters := h.word32(sharedWaiters)
+		atomic.AddUint32(waiters, 1)
+		defer atomic.AddUint32(waiters, ^uint32(0))
+		timeout = 0
+	}
+	last := atomic.LoadUint64(h.word(sharedCommit))
+	for {
+		v := atomic.LoadUint32(notify)
+		select {
+		case <-c.done:
+			return
+		default:
+		}
+		if commit := atomic.LoadUint64(h.word(sharedCommit)); commit != last {
+			last = commit
+			c.notifyHead()
+		}
+		futexSleep(notify, v, timeout)
+	}
+}
+
+// stopWatcher ends watchShared, if it was started, before Close unmaps the
+// shared header. c.done must already be closed.
+func (c *RingBufferCache) stopWatcher() {
+	c.notifyMu.Lock()
+	done := c.watchDone
+	c.notifyMu.Unlock()
+	if done == nil {
+		return
+	}
+	notify := c.shared.word32(sharedNotify)
+	if c.shared.writable {
+		atomic.AddUint32(notify, 1) // a watcher about to sleep sees the change
+	}
+	futexWakeAll(notify)
+	<-done
+}
@@ -0,0 +1,160 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestWaitForHeadInProcess(t *testing.T) {
+	cache, _ := newTestCache(t, 8, 4)
+	cache.WriteHead([]byte("r001"), false)
+
+	if id, err := cache.WaitForHead(context.Background(), 0); err != nil || id != 1 {
+		t.Fatalf("WaitForHead for an existing record = %d, %v", id, err)
+	}
+
+	got := make(chan int64, 1)
+	go func() {
+		id, err := cache.WaitForHead(context.Background(), 1)
+		if err != nil {
+			t.Errorf("WaitForHead: %v", err)
+		}
+		got <- id
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cache.WriteHead([]byte("r002"), false)
+	if id := <-got; id != 2 {
+		t.Fatalf("WaitForHead woke with head %d, want 2", id)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := cache.WaitForHead(ctx, 2); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("WaitForHead past deadline: %v", err)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := cache.WaitForHead(context.Background(), 2)
+		errc <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cache.Close()
+	if err := <-errc; !errors.Is(err, ErrClosed) {
+		t.Fatalf("WaitForHead across Close: %v", err)
+	}
+}
+
+func TestWaitForHeadReadOnly(t *testing.T) {
+	cache, base := newTestCache(t, 8, 4)
+	defer cache.Close()
+	reader, err := OpenReadOnly(base, DefaultOptions())
+	if err != nil {
+		t.Fatalf("OpenReadOnly: %v", err)
+	}
+
+	ch, err := reader.Subscribe(context.Background(), reader.Head())
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	go func() {
+		for i := 1; i <= 3; i++ {
+			time.Sleep(5 * time.Millisecond)
+			cache.WriteHead([]byte(fmt.Sprintf("r%03d", i)), false)
+		}
+	}()
+	if id, err := reader.WaitForHead(context.Background(), 0); err != nil || id < 1 {
+		t.Fatalf("reader WaitForHead = %d, %v", id, err)
+	}
+	for i := 1; i <= 3; i++ {
+		select {
+		case rec := <-ch:
+			if want := fmt.Sprintf("r%03d", i); rec.Err != nil || string(rec.Payload) != want {
+				t.Fatalf("record %d = %q, %v", i, rec.Payload, rec.Err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("reader subscription missed record %d", i)
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := reader.WaitForHead(context.Background(), 3)
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if err := reader.Close(); err != nil {
+		t.Fatalf("reader Close: %v", err)
+	}
+	if err := <-done; !errors.Is(err, ErrClosed) {
+		t.Fatalf("reader WaitForHead across Close: %v", err)
+	}
+	if _, ok := <-ch; ok {
+		t.Fatalf("subscription open after Close")
+	}
+}
+
+// TestWaitForHeadWriterProcess is the writer side of
+// TestWaitForHeadAcrossProcesses, run in a child process.
+func TestWaitForHeadWriterProcess(t *testing.T) {
+	base := os.Getenv("ARCHIVE_WRITER_BASE")
+	if base == "" {
+		t.Skip("only run as a child process")
+	}
+	opts := DefaultOptions()
+	opts.ConfigPolicy = ConfigAdopt
+	opts.UseMmap = false
+	cache, err := NewRingBufferCacheWithOptions(base, opts)
+	if err != nil {
+		t.Fatalf("open writer: %v", err)
+	}
+	defer cache.Close()
+	for i := 1; i <= 5; i++ {
+		time.Sleep(10 * time.Millisecond)
+		cache.WriteHead([]byte(fmt.Sprintf("p%03d", i)), false)
+	}
+}
+
+func TestWaitForHeadAcrossProcesses(t *testing.T) {
+	if testing.Short() {
+		t.Skip("spawns a child process")
+	}
+	cache, base := newTestCache(t, 8, 4)
+	cache.Close()
+	reader, err := OpenReadOnly(base, DefaultOptions())
+	if err != nil {
+		t.Fatalf("OpenReadOnly: %v", err)
+	}
+	defer reader.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestWaitForHeadWriterProcess$")
+	cmd.Env = append(os.Environ(), "ARCHIVE_WRITER_BASE="+base)
+	out := make(chan error, 1)
+	go func() {
+		b, err := cmd.CombinedOutput()
+		if err != nil {
+			err = fmt.Errorf("%w: %s", err, b)
+		}
+		out <- err
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	head := reader.Head()
+	for head != 5 {
+		if head, err = reader.WaitForHead(ctx, head); err != nil {
+			t.Fatalf("WaitForHead: %v", err)
+		}
+	}
+	if got, err := reader.Read(5); err != nil || string(got) != "p005" {
+		t.Fatalf("Read(5) = %q, %v", got, err)
+	}
+	if err := <-out; err != nil {
+		t.Fatalf("writer process: %v", err)
+	}
+}
@@ -6,18 +6,51 @@ package archive
 //   - ShardCount:  jumlah shard untuk memecah file besar (0 = single file)
 //   - BufferPoolSize: ukuran pool buffer untuk mengurangi alokasi (0 = nonaktif)
 //   - PrefetchSize:   jumlah record diprefetch saat membaca (0 = nonaktif)
+//   - PrefetchWorkers: jumlah worker prefetch tetap (0 = default 2)
+//   - VariableLength: simpan panjang payload per record; RecordSize menjadi
+//     kapasitas satu slot dan payload lebih panjang memakai beberapa slot
+//   - Sequenced:      simpan nomor urut 64-bit di header tiap slot sehingga
+//     pembacaan ID lama setelah wrap dapat dideteksi (lihat ReadSeq)
+//   - Timestamped:    simpan waktu tulis (Unix ns) di header tiap slot untuk
+//     SeekTime dan RangeByTime
+//   - GroupCommit:    flush dari beberapa writer bersamaan berbagi satu
+//     fsync/msync per shard, alih-alih masing-masing menyinkronkan rentangnya
+//   - Durability:     kapan tulisan di-sync ke disk (per panggilan, selalu,
+//     tiap N record, tiap interval, atau diserahkan ke OS); lihat DurabilityPolicy
+//   - Retention:      batas umur dan/atau jumlah record; record yang lewat
+//     batas dipangkas dari Tail() di latar belakang (lihat RetentionPolicy)
+//   - Codec:          kompresi payload per record (nil = tanpa kompresi);
+//     wajib VariableLength, lihat Codec
+//   - Cipher:         enkripsi AES-256-GCM per record (nil = plaintext);
+//     wajib VariableLength, lihat Cipher
+//   - Checksum:       algoritma checksum di header slot (default ChecksumIEEE);
+//     lebar header mengikuti algoritma, lihat ChecksumAlgo
+//   - ConfigPolicy:   sikap bila opsi berbeda dengan file .cfg yang sudah ada
+//     (ConfigAdopt, ConfigStrict, atau ConfigMigrate); tidak dipersist
 //
 // Semua bidang bersifat opsi; nilai 0 artinya gunakan default.
 // Lihat DefaultOptions() untuk nilai bawaan.
 type CacheOptions struct {
 	// Ring ID allocation range
-	MinIDAlloc     int64 // ID pertama yang akan digunakan (default 1)
-	MaxIDAlloc     int64 // Batas maksimal ID (0 = sama dengan size)
-	UseMmap        bool  // Gunakan memory-mapping untuk performa lebih baik
-	ShardCount     int   // Jumlah shard (0 = single file)
-	RecordSize     int   // Ukuran payload setiap record (byte), wajib >0
-	BufferPoolSize int   // Ukuran pool buffer (0 = disable)
-	PrefetchSize   int   // Prefetch N records ke depan (0 = disable)
+	MinIDAlloc      int64 // ID pertama yang akan digunakan (default 1)
+	MaxIDAlloc      int64 // Batas maksimal ID (0 = sama dengan size)
+	UseMmap         bool  // Gunakan memory-mapping untuk performa lebih baik
+	ShardCount      int   // Jumlah shard (0 = single file)
+	RecordSize      int   // Ukuran payload setiap record (byte), wajib >0
+	BufferPoolSize  int   // Ukuran pool buffer (0 = disable)
+	PrefetchSize    int   // Prefetch N records ke depan (0 = disable)
+	PrefetchWorkers int   // Jumlah worker prefetch (0 = default 2)
+	VariableLength  bool  // Payload panjang bebas, RecordSize = kapasitas per slot
+	Sequenced       bool  // Header slot memuat nomor urut tulis (sequence)
+	Timestamped     bool  // Header slot memuat waktu tulis (lihat SeekTime)
+	GroupCommit     bool  // Writer bersamaan berbagi satu fsync per shard
+
+	Durability   DurabilityPolicy // Kebijakan sync (default: mengikuti argumen flush)
+	Retention    RetentionPolicy  // Kebijakan kedaluwarsa record (default: tidak ada)
+	Codec        Codec            // Kompresi payload (default nil = tanpa kompresi)
+	Cipher       *Cipher          // Enkripsi payload (default nil = plaintext)
+	Checksum     ChecksumAlgo     // Checksum header slot (default ChecksumIEEE)
+	ConfigPolicy ConfigPolicy     // Perilaku saat opsi tidak cocok dengan .cfg (default ConfigAdopt)
 }
 
 // DefaultOptions mengembalikan konfigurasi default yang digunakan NewRingBufferCache.
@@ -0,0 +1,177 @@
+package archive
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Prefetch works on blocks of PrefetchSize consecutive IDs. After every Read
+// the blocks covering the next PrefetchSize IDs (wrapping at MaxIDAlloc, and
+// therefore possibly spanning shards) are queued for a fixed pool of workers.
+// A block that is already queued, or was warmed less than prefetchRecent ago,
+// is not queued again; when the queue is full the request is dropped rather
+// than blocking the reader.
+//
+// Workers warm mmap shards with madvise(MADV_WILLNEED) and plain-file shards
+// with a single pread of the block, so prefetching never touches hit/miss
+// statistics or decodes records.
+
+const (
+	defaultPrefetchWorkers = 2
+	prefetchQueuePerWorker = 64
+	prefetchRecentSlots    = 256
+	prefetchRecent         = time.Second
+)
+
+type prefetcher struct {
+	c       *RingBufferCache
+	workers int
+	block   int64 // IDs per block
+	queue   chan int64
+	quit    chan struct{} // closed to pause the workers
+	wg      sync.WaitGroup
+
+	mu      sync.Mutex
+	pending map[int64]struct{}             // blocks queued or being warmed
+	recent  [prefetchRecentSlots]recentBlk // direct-mapped record of warmed blocks
+
+	queued  uint64
+	deduped uint64
+	dropped uint64
+	slots   uint64
+}
+
+type recentBlk struct {
+	block int64
+	at    time.Time
+}
+
+// startPrefetcher launches the worker pool; it returns nil when prefetching
+// is disabled.
+func (c *RingBufferCache) startPrefetcher() *prefetcher {
+	if c.options.PrefetchSize <= 0 {
+		return nil
+	}
+	workers := c.options.PrefetchWorkers
+	if workers <= 0 {
+		workers = defaultPrefetchWorkers
+	}
+	p := &prefetcher{
+		c:       c,
+		workers: workers,
+		block:   int64(c.options.PrefetchSize),
+		queue:   make(chan int64, workers*prefetchQueuePerWorker),
+		pending: make(map[int64]struct{}),
+	}
+	for i := range p.recent {
+		p.recent[i].block = -1
+	}
+	p.start()
+	return p
+}
+
+// start launches the workers, again after pause.
+func (p *prefetcher) start() {
+	p.quit = make(chan struct{})
+	p.wg.Add(p.workers)
+	for i := 0; i < p.workers; i++ {
+		go p.run(p.quit)
+	}
+}
+
+// schedule queues the blocks covering the PrefetchSize IDs after id.
+func (p *prefetcher) schedule(id int64) {
+	c := p.c
+	first := c.nextID(id) - c.minIDAlloc
+	last := first + p.block - 1
+	nBlocks := (c.size + p.block - 1) / p.block
+	for b := first / p.block; b <= last/p.block; b++ {
+		p.enqueue(b % nBlocks)
+	}
+}
+
+func (p *prefetcher) enqueue(block int64) {
+	p.mu.Lock()
+	r := p.recent[block%prefetchRecentSlots]
+	_, busy := p.pending[block]
+	if busy || (r.block == block && time.Since(r.at) < prefetchRecent) {
+		p.mu.Unlock()
+		atomic.AddUint64(&p.deduped, 1)
+		return
+	}
+	p.pending[block] = struct{}{}
+	p.mu.Unlock()
+
+	select {
+	case p.queue <- block:
+		atomic.AddUint64(&p.queued, 1)
+	default:
+		p.mu.Lock()
+		delete(p.pending, block)
+		p.mu.Unlock()
+		atomic.AddUint64(&p.dropped, 1)
+	}
+}
+
+func (p *prefetcher) run(quit chan struct{}) {
+	defer p.wg.Done()
+	var scratch []byte
+	for {
+		select {
+		case <-p.c.done:
+			return
+		case <-quit:
+			return
+		case block := <-p.queue:
+			scratch = p.warm(block, scratch)
+			p.mu.Lock()
+			delete(p.pending, block)
+			p.recent[block%prefetchRecentSlots] = recentBlk{block: block, at: time.Now()}
+			p.mu.Unlock()
+		}
+	}
+}
+
+// warm pulls the slots of block into the page cache, splitting it at shard
+// boundaries. scratch is reused between calls for plain-file reads.
+func (p *prefetcher) warm(block int64, scratch []byte) []byte {
+	c := p.c
+	rel := block*p.block + 1
+	end := min(rel+p.block, c.size+1)
+	for _, s := range c.shards {
+		from, to := max(rel, s.offset+1), min(end, s.offset+s.size+1)
+		if from >= to {
+			continue
+		}
+		off := (from - s.offset - 1) * int64(c.diskRec)
+		n := (to - from) * int64(c.diskRec)
+		if s.mmap != nil {
+			page := int64(os.Getpagesize())
+			start := off &^ (page - 1)
+			unix.Madvise(s.mmap[start:off+n], unix.MADV_WILLNEED)
+		} else {
+			if int64(cap(scratch)) < n {
+				scratch = make([]byte, n)
+			}
+			s.file.ReadAt(scratch[:n], off)
+		}
+		atomic.AddUint64(&p.slots, uint64(to-from))
+	}
+	return scratch
+}
+
+// stop waits for the workers to exit; c.done must already be closed.
+func (p *prefetcher) stop() {
+	p.wg.Wait()
+}
+
+// pause stops the workers while the shards are replaced; queued blocks are
+// warmed after restart.
+func (p *prefetcher) pause() {
+	close(p.quit)
+	p.wg.Wait()
+}
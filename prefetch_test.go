@@ -0,0 +1,54 @@
+package archive
+
+import (
+	"testing"
+	"time"
+)
+
+func waitPrefetchSlots(t *testing.T, c *RingBufferCache, want uint64) Stats {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		st := c.GetStats()
+		if st.PrefetchSlots >= want {
+			return st
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("prefetch warmed %d slots, want %d (%+v)", st.PrefetchSlots, want, st)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPrefetchPool(t *testing.T) {
+	for _, useMmap := range []bool{false, true} {
+		opts := DefaultOptions()
+		opts.MaxIDAlloc = 10
+		// shards hold IDs 1-4, 5-8, 9-10
+		cache, _ := newTestCacheWithOpts(t, 10, 8, opts, withShards(3), withMmap(useMmap), withPrefetch(3, 1))
+		defer cache.Close()
+		for id := int64(1); id <= 10; id++ {
+			cache.Write(id, []byte("abcdefgh"), false)
+		}
+
+		// block 4..6 straddles the first and second shard
+		if _, err := cache.Read(3); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		waitPrefetchSlots(t, cache, 3)
+
+		// the last ID wraps around to block 1..3
+		cache.Read(10)
+		waitPrefetchSlots(t, cache, 6)
+
+		// 4..6 was warmed moments ago and is not queued again
+		cache.Read(3)
+		st := cache.GetStats()
+		if st.PrefetchQueued != 2 || st.PrefetchDeduped != 1 {
+			t.Fatalf("mmap=%v: unexpected prefetch stats %+v", useMmap, st)
+		}
+		if st.Hits != 3 || st.Misses != 0 {
+			t.Fatalf("mmap=%v: prefetch leaked into hit stats %+v", useMmap, st)
+		}
+	}
+}
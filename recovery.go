@@ -0,0 +1,153 @@
+package archive
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// RecoveryReport describes how head and tail were established when the cache
+// was opened.
+type RecoveryReport struct {
+	Clean    bool   // .meta was written by a clean Close and trusted as is
+	Fresh    bool   // cache was created by this open
+	Scanned  int64  // slots examined by the recovery scan
+	Valid    int64  // slots whose checksum verified
+	Empty    int64  // slots never written (all zero)
+	Corrupt  int64  // slots with a checksum mismatch that are not empty
+	MetaHead int64  // head found in .meta before recovery (0 when missing)
+	MetaTail int64  // tail found in .meta before recovery (0 when missing)
+	Head     int64  // head after recovery
+	Tail     int64  // tail after recovery
+	Seq      uint64 // logical write count after recovery
+	Repaired bool   // recovery changed head, tail or seq and rewrote .meta
+}
+
+// Recovery returns the report of the recovery pass run by the constructor.
+func (c *RingBufferCache) Recovery() RecoveryReport { return c.recovery }
+
+// loadOrRecover establishes head, tail and seq. A cleanly closed cache trusts
+// its .meta file; otherwise every slot is validated and the true head is
+// derived from the slot sequence numbers (or, for unsequenced caches that
+// have not wrapped yet, from the run of valid slots after the recorded head).
+// The .meta file is then rewritten and marked dirty until the next Close.
+func (c *RingBufferCache) loadOrRecover(fresh bool) (RecoveryReport, error) {
+	r := RecoveryReport{Fresh: fresh}
+
+	m, gen, metaErr := loadMeta(c.metaPath)
+	c.metaGen = gen
+	switch {
+	case fresh || metaErr != nil:
+		c.setMeta(c.positionAt(0))
+	default:
+		r.Clean = m.clean
+		r.MetaHead, r.MetaTail = int64(m.head), int64(m.tail)
+		c.setMeta(m)
+	}
+
+	if !fresh && !r.Clean {
+		before := c.metaState(false)
+		if err := c.scan(&r); err != nil {
+			return r, err
+		}
+		r.Repaired = metaErr != nil || c.metaState(false) != before
+	}
+
+	r.Head, r.Tail, r.Seq = c.Head(), c.Tail(), c.Seq()
+	if err := c.saveMeta(c.metaState(false)); err != nil {
+		return r, fmt.Errorf("save meta: %w", err)
+	}
+	return r, nil
+}
+
+// scan validates every slot and repairs head, tail and seq from what is
+// actually on disk.
+func (c *RingBufferCache) scan(r *RecoveryReport) error {
+	var maxSeq uint64
+	err := c.scanSlots(func(id int64, slot []byte) {
+		r.Scanned++
+		h, _, err := c.decodeSlot(slot)
+		switch {
+		case err == nil:
+			r.Valid++
+			maxSeq = max(maxSeq, h.seq)
+		case isZero(slot):
+			r.Empty++
+		default:
+			r.Corrupt++
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if c.layout.seqOff > 0 {
+		if maxSeq > 0 {
+			c.setMeta(c.positionAt(maxSeq))
+		}
+		// with nothing sequenced on disk keep what .meta says
+		return nil
+	}
+
+	// Unsequenced slots carry no ordering; once the ring has wrapped every
+	// slot is valid and .meta is the only source of truth.
+	min := uint64(c.minIDAlloc)
+	if atomic.LoadUint64(&c.seq) > uint64(c.size) {
+		return nil
+	}
+	head := atomic.LoadUint64(&c.head)
+	for next := head + 1; next <= c.maxIDAlloc; next++ {
+		if !c.slotValid(int64(next)) {
+			break
+		}
+		head = next
+	}
+	c.setMeta(c.positionAt(head - min + 1))
+	return nil
+}
+
+// scanSlots calls fn with the raw bytes of every slot, reading shards in
+// large chunks.
+func (c *RingBufferCache) scanSlots(fn func(id int64, slot []byte)) error {
+	per := int64(max(1, (1<<20)/c.diskRec))
+	buf := make([]byte, per*int64(c.diskRec))
+	for i, s := range c.shards {
+		for first := int64(0); first < s.size; first += per {
+			n := min(per, s.size-first)
+			chunk := buf[:n*int64(c.diskRec)]
+			if err := s.readSlot(chunk, first*int64(c.diskRec)); err != nil {
+				return fmt.Errorf("scan shard %d: %w", i, err)
+			}
+			for j := int64(0); j < n; j++ {
+				id := c.minIDAlloc + s.offset + first + j
+				fn(id, chunk[j*int64(c.diskRec):(j+1)*int64(c.diskRec)])
+			}
+		}
+	}
+	return nil
+}
+
+// slotValid reports whether the slot at id holds a record with a valid
+// checksum.
+func (c *RingBufferCache) slotValid(id int64) bool {
+	shard, offset, err := c.slotAt(id)
+	if err != nil {
+		return false
+	}
+	bp := c.getBufFromPool()
+	defer c.returnBufToPool(bp)
+	buf := *bp
+	if err := shard.readSlot(buf, offset); err != nil {
+		return false
+	}
+	_, _, err = c.decodeSlot(buf)
+	return err == nil
+}
+
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,216 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// ReshardOptions configures Reshard.
+type ReshardOptions struct {
+	// Cache supplies the runtime options used to open the cache, such as
+	// Cipher for an encrypted cache; the layout is read from .cfg.
+	Cache CacheOptions
+	// Progress, if set, is called at every checkpoint and once the copy is
+	// complete.
+	Progress func(ReshardProgress)
+}
+
+// ReshardProgress reports how far Reshard got. Both counts are in slots and
+// include the work of interrupted earlier runs.
+type ReshardProgress struct {
+	Copied uint64
+	Total  uint64
+}
+
+// reshardStep is the number of slots copied between two checkpoints.
+var reshardStep uint64 = 1 << 16
+
+// reshardState is the checkpoint of a reshard in progress, kept in
+// <base>.reshard.state next to the target.
+type reshardState struct {
+	ShardCount int   `json:"shard_count"`
+	Copied     int64 `json:"copied_slots"` // target holds the slots of the first Copied IDs
+}
+
+func reshardPath(base string) string { return base + ".reshard" }
+
+func reshardStatePath(base string) string { return reshardPath(base) + ".state" }
+
+// discardReshard removes the target and the checkpoint of an interrupted
+// Reshard of the cache at base.
+func discardReshard(base string) error {
+	if err := removeCacheFiles(reshardPath(base)); err != nil {
+		return err
+	}
+	if err := os.Remove(reshardStatePath(base)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func readReshardState(path string) (reshardState, error) {
+	var st reshardState
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return st, err
+	}
+	err = json.Unmarshal(data, &st)
+	return st, err
+}
+
+func writeReshardState(path string, st reshardState) error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// Reshard redistributes the records of the cache at basePath over newCount
+// shard files. It takes the writer lock for the whole run, so the cache must
+// not be open for writing elsewhere; read-only processes may keep reading
+// and reopen the shards once the new layout is in place.
+//
+// Every slot is copied as it is into <basePath>.reshard, with a checkpoint
+// every 65536 slots, and then swapped in with the crash-safe plan used by
+// ConfigMigrate; .cfg is replaced last. An interrupted run is continued from
+// its last checkpoint when Reshard is called again with the same count.
+// Opening the cache for writing in between discards the checkpoint, so the
+// copy starts over. IDs, sequence numbers and slot headers are unchanged.
+func Reshard(basePath string, newCount int, opts ReshardOptions) error {
+	if newCount <= 0 {
+		return fmt.Errorf("shard count must be positive")
+	}
+	copts := opts.Cache
+	copts.ConfigPolicy = ConfigAdopt
+	// opened without NewRingBufferCacheWithOptions, which would discard the
+	// checkpoint of an earlier run
+	src, err := openWriter(basePath, copts)
+	if err != nil {
+		return err
+	}
+	if len(src.shards) == newCount {
+		// nothing to do, or a previous run already swapped the files
+		err := discardReshard(basePath)
+		if cerr := src.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}
+	if src.size < int64(newCount) {
+		src.Close()
+		return fmt.Errorf("%d IDs cannot fill %d shards", src.size, newCount)
+	}
+	plan, err := src.reshardCopy(newCount, opts.Progress)
+	if err != nil {
+		src.Close()
+		return fmt.Errorf("reshard %s: %w", basePath, err)
+	}
+	// the files are replaced under the open source, so its .meta must not
+	// be written again
+	err = installLayout(basePath, plan)
+	if cerr := src.shutdown(false); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// reshardCopy copies every slot into the reshard target, resuming from the
+// checkpoint of an earlier run, and returns the plan that swaps the target
+// into place.
+func (c *RingBufferCache) reshardCopy(newCount int, progress func(ReshardProgress)) (swapPlan, error) {
+	tmp, statePath := reshardPath(c.basePath), reshardStatePath(c.basePath)
+	opts := c.options
+	opts.ShardCount = newCount
+	opts.ConfigPolicy = ConfigStrict
+
+	st, err := readReshardState(statePath)
+	resume := err == nil && st.ShardCount == newCount && st.Copied >= 0 && st.Copied <= c.size
+	var dst *RingBufferCache
+	if resume {
+		dst, err = openCache(tmp, opts, false)
+		resume = err == nil
+	}
+	if !resume {
+		// start over
+		st = reshardState{ShardCount: newCount}
+		if err := removeCacheFiles(tmp); err != nil {
+			return swapPlan{}, err
+		}
+		if dst, err = openCache(tmp, opts, false); err != nil {
+			return swapPlan{}, err
+		}
+		if dst.gens != nil {
+			// the copied records were sealed with generations of the source
+			v, err := readGeneration(genPath(c.basePath))
+			if err == nil {
+				err = dst.gens.seed(v)
+			}
+			if err != nil {
+				dst.Close()
+				return swapPlan{}, err
+			}
+		}
+		if err := writeReshardState(statePath, st); err != nil {
+			dst.Close()
+			return swapPlan{}, err
+		}
+	}
+
+	buf, zero := make([]byte, c.diskRec), make([]byte, c.diskRec)
+	for err == nil {
+		end := min(st.Copied+int64(reshardStep), c.size)
+		for ; st.Copied < end && err == nil; st.Copied++ {
+			err = copySlot(c, dst, c.minIDAlloc+st.Copied, buf, zero)
+		}
+		if err != nil {
+			break
+		}
+		if err = dst.Flush(); err == nil {
+			err = writeReshardState(statePath, st)
+		}
+		if err == nil && progress != nil {
+			progress(ReshardProgress{Copied: uint64(st.Copied), Total: uint64(c.size)})
+		}
+		if st.Copied == c.size {
+			break
+		}
+	}
+	if err == nil {
+		dst.setMeta(c.metaState(false)) // position and trimmed range of the source
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return swapPlan{}, err // the target is kept for the next run
+	}
+	if err := os.Remove(sharedPath(tmp)); err != nil {
+		return swapPlan{}, err
+	}
+	plan := planSwap(c.basePath, len(c.shards), tmp, newCount)
+	plan.Removes = append(plan.Removes, statePath)
+	return plan, nil
+}
+
+// copySlot copies the raw slot at id from src to dst, which share the slot
+// layout. Slots that were never written stay holes in dst.
+func copySlot(src, dst *RingBufferCache, id int64, buf, zero []byte) error {
+	s, off, err := src.slotAt(id)
+	if err != nil {
+		return err
+	}
+	if err := s.readSlot(buf, off); err != nil {
+		return src.recordErr(id, s, off, err)
+	}
+	if bytes.Equal(buf, zero) {
+		return nil
+	}
+	d, doff, err := dst.slotAt(id)
+	if err != nil {
+		return err
+	}
+	return dst.recordErr(id, d, doff, d.writeSlot(buf, doff))
+}
@@ -0,0 +1,225 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReshard(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxIDAlloc = 32
+	opts.Sequenced = true
+	cache, base := newTestCacheWithOpts(t, 32, 4, opts)
+	writeN(t, cache, 1, 40)
+	cache.Close()
+
+	ropts := ReshardOptions{Cache: DefaultOptions()}
+	var last ReshardProgress
+	ropts.Progress = func(p ReshardProgress) { last = p }
+	if err := Reshard(base, 4, ropts); err != nil {
+		t.Fatalf("Reshard: %v", err)
+	}
+	if last.Copied != 32 || last.Total != 32 {
+		t.Fatalf("last progress %+v", last)
+	}
+	for i := 0; i < 4; i++ {
+		if _, err := os.Stat(shardPath(base, 4, i)); err != nil {
+			t.Fatalf("shard %d: %v", i, err)
+		}
+	}
+	if _, err := os.Stat(base); !os.IsNotExist(err) {
+		t.Fatalf("single-shard file left behind: %v", err)
+	}
+	if _, err := os.Stat(reshardStatePath(base)); !os.IsNotExist(err) {
+		t.Fatalf("checkpoint left behind: %v", err)
+	}
+	cfg, _ := os.ReadFile(base + ".cfg")
+	if !strings.Contains(string(cfg), `"shard_count": 4`) {
+		t.Fatalf(".cfg not switched:\n%s", cfg)
+	}
+
+	if err := Reshard(base, 3, ReshardOptions{Cache: DefaultOptions()}); err != nil {
+		t.Fatalf("Reshard to 3: %v", err)
+	}
+	reopened, err := NewRingBufferCacheWithOptions(base, DefaultOptions())
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	if reopened.ShardCount() != 3 || reopened.Head() != 8 || reopened.Seq() != 40 {
+		t.Fatalf("shards=%d head=%d seq=%d", reopened.ShardCount(), reopened.Head(), reopened.Seq())
+	}
+	checkSeqs(t, reopened, 9, 40)
+	if _, err := os.Stat(shardPath(base, 4, 3)); !os.IsNotExist(err) {
+		t.Fatalf("stale shard left behind: %v", err)
+	}
+}
+
+func TestReshardResume(t *testing.T) {
+	defer func(step uint64) { reshardStep = step }(reshardStep)
+	reshardStep = 8
+
+	opts := DefaultOptions()
+	opts.MaxIDAlloc = 32
+	opts.Sequenced = true
+	cache, base := newTestCacheWithOpts(t, 32, 4, opts)
+	writeN(t, cache, 1, 40)
+	cache.Close()
+
+	// interrupt a run after its first checkpoint
+	interrupt := func(count int) {
+		src, err := NewRingBufferCacheWithOptions(base, DefaultOptions())
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		defer crash(src)
+		defer func() { recover() }()
+		src.reshardCopy(count, func(ReshardProgress) { panic("killed") })
+	}
+	interrupt(2)
+
+	var seen []uint64
+	ropts := ReshardOptions{Cache: DefaultOptions()}
+	ropts.Progress = func(p ReshardProgress) { seen = append(seen, p.Copied) }
+	if err := Reshard(base, 2, ropts); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if len(seen) == 0 || seen[0] != 16 || seen[len(seen)-1] != 32 {
+		t.Fatalf("resumed run reported %v, want 16 first", seen)
+	}
+	reopened, err := NewRingBufferCacheWithOptions(base, DefaultOptions())
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	checkSeqs(t, reopened, 9, 40)
+
+	// a write after the interruption invalidates the checkpoint
+	reopened.Close()
+	interrupt(4)
+	reopened, _ = NewRingBufferCacheWithOptions(base, DefaultOptions())
+	writeN(t, reopened, 41, 41)
+	reopened.Close()
+	seen = nil
+	if err := Reshard(base, 4, ropts); err != nil {
+		t.Fatalf("restart: %v", err)
+	}
+	if seen[0] != 8 {
+		t.Fatalf("restarted run reported %v, want 8 first", seen)
+	}
+	reopened, _ = NewRingBufferCacheWithOptions(base, DefaultOptions())
+	defer reopened.Close()
+	if reopened.ShardCount() != 4 {
+		t.Fatalf("ShardCount = %d", reopened.ShardCount())
+	}
+	checkSeqs(t, reopened, 10, 41)
+}
+
+func TestReshardWrittenIDs(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxIDAlloc = 16
+	opts.VariableLength = true
+	cache, base := newTestCacheWithOpts(t, 16, 4, opts)
+	writeN(t, cache, 1, 2)
+	written := map[int64]string{1: "r001", 2: "r002", 5: "w5", 9: "spans slots", 16: "last"}
+	for _, id := range []int64{5, 9, 16} {
+		if err := cache.Write(id, []byte(written[id]), false); err != nil {
+			t.Fatalf("Write(%d): %v", id, err)
+		}
+	}
+	cache.Close()
+
+	if err := Reshard(base, 3, ReshardOptions{Cache: DefaultOptions()}); err != nil {
+		t.Fatalf("Reshard: %v", err)
+	}
+	reopened, err := NewRingBufferCacheWithOptions(base, DefaultOptions())
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	if reopened.ShardCount() != 3 || reopened.Seq() != 2 {
+		t.Fatalf("shards=%d seq=%d", reopened.ShardCount(), reopened.Seq())
+	}
+	for id, want := range written {
+		if got, err := reopened.Read(id); err != nil || string(got) != want {
+			t.Fatalf("Read(%d) = %q, %v, want %q", id, got, err, want)
+		}
+	}
+}
+
+func TestReshardKeepsGenerations(t *testing.T) {
+	cache, base := newTestCacheWithOpts(t, 64, 64, cipherOptions(nil))
+	writeN(t, cache, 1, 3)
+	opts := cache.options
+	cache.Close()
+	before, err := readGeneration(genPath(base))
+	if err != nil || before == 0 {
+		t.Fatalf(".gen before = %d, %v", before, err)
+	}
+
+	// the raw copy seals nothing, so the target must carry the source's .gen
+	if err := Reshard(base, 2, ReshardOptions{Cache: opts}); err != nil {
+		t.Fatalf("Reshard: %v", err)
+	}
+	if after, err := readGeneration(genPath(base)); err != nil || after < before {
+		t.Fatalf(".gen after = %d, %v, want at least %d", after, err, before)
+	}
+	reopened, err := NewRingBufferCacheWithOptions(base, opts)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	id, _ := reopened.WriteHead([]byte("four"), false)
+	if reopened.gens.cur <= before {
+		t.Fatalf("generation %d reused after Reshard (reserved up to %d)", reopened.gens.cur, before)
+	}
+	checkIDs(t, reopened, map[int64]int{1: 1, 2: 2, 3: 3})
+	if got, err := reopened.Read(id); err != nil || string(got) != "four" {
+		t.Fatalf("Read = %q, %v", got, err)
+	}
+}
+
+func TestReshardPatternPath(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "a[b")
+	base := filepath.Join(dir, "cache.data")
+	opts := DefaultOptions()
+	opts.UseMmap = false
+	opts.MaxIDAlloc = 16
+	opts.ShardCount = 1
+	opts.RecordSize = 4
+	opts.PrefetchSize = 0
+	cache, err := NewRingBufferCacheWithOptions(base, opts)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	writeN(t, cache, 1, 4)
+	cur, _ := cache.Cursor("app")
+	rec, _ := cur.Next()
+	if err := cur.Commit(rec.ID); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	cache.Close()
+	// only the files of the checkpoint are discarded, not every name that
+	// starts with it
+	decoy := reshardPath(base) + "-notes"
+	if err := os.WriteFile(decoy, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Reshard(base, 2, ReshardOptions{Cache: DefaultOptions()}); err != nil {
+		t.Fatalf("Reshard: %v", err)
+	}
+	reopened, err := NewRingBufferCacheWithOptions(base, DefaultOptions())
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	checkIDs(t, reopened, map[int64]int{1: 1, 4: 4})
+	if cur, _ := reopened.Cursor("app"); cur.Committed() != 1 {
+		t.Fatalf("cursor committed ID %d, want 1", cur.Committed())
+	}
+	if _, err := os.Stat(decoy); err != nil {
+		t.Fatalf("unrelated file removed: %v", err)
+	}
+}
@@ -0,0 +1,243 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync/atomic"
+	"time"
+)
+
+// Resize changes the ID range of an open cache to newMin..newMax while
+// readers and writers keep using it.
+//
+// New operations wait while every record is copied into a ring of the new
+// size next to the cache, which is then swapped into place with the
+// crash-safe plan used by ConfigMigrate: a crash during the swap leaves
+// either the old or the new ring, and the next open finishes it.
+//
+// Records keep their ID whenever it lies in the new range and its slots are
+// not taken by newer records. The others, typically the records above a
+// lowered MaxIDAlloc, are moved to the free slots next to the newest ones
+// and get a new ID; when shrinking, the oldest records that find no room
+// are dropped. Sequence numbers are renumbered when the head moves to a
+// different lap of the new ring: committed cursor positions are translated,
+// but sequence numbers kept elsewhere, e.g. for ReadSeq, become stale, and a
+// running Subscribe may deliver records again.
+//
+// Read-only processes notice the swap through the shared header and reopen
+// the shard files on their next operation. If the swap fails after it
+// started, the cache is closed without writing .meta and the error says so;
+// reopening the cache completes the resize.
+func (c *RingBufferCache) Resize(newMin, newMax int64) error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	if newMax <= newMin {
+		return fmt.Errorf("MaxIDAlloc must be greater than MinIDAlloc")
+	}
+	if size := newMax - newMin + 1; size < int64(c.options.ShardCount) {
+		return fmt.Errorf("%d IDs cannot fill %d shards", size, c.options.ShardCount)
+	}
+	if err := c.pause(); err != nil {
+		return err
+	}
+	swapped, err := c.resize(newMin, newMax)
+	c.resume()
+	if err != nil && swapped {
+		c.shutdown(false)
+		return fmt.Errorf("resize %s: %w (cache closed; reopen it to finish the resize)", c.basePath, err)
+	}
+	return err
+}
+
+// resize rebuilds the ring for newMin..newMax while the cache is paused. It
+// reports whether the swap of the files had started.
+func (c *RingBufferCache) resize(newMin, newMax int64) (bool, error) {
+	if newMin == c.minIDAlloc && uint64(newMax) == c.maxIDAlloc {
+		return false, nil
+	}
+	opts := c.options
+	opts.MinIDAlloc, opts.MaxIDAlloc = newMin, newMax
+	plan, err := buildMigration(c, c.basePath, opts, true)
+	if err != nil {
+		return false, err
+	}
+	if err := installLayout(c.basePath, plan); err != nil {
+		return true, err
+	}
+	shards, err := openShards(c.basePath, opts, newMax-newMin+1, c.diskRec, false)
+	if err != nil {
+		return true, err
+	}
+	if err := c.switchLayout(opts, shards); err != nil {
+		return true, err
+	}
+	if err := c.reloadCursors(); err != nil {
+		return true, err
+	}
+	if c.gens != nil {
+		g, err := loadGenerations(genPath(c.basePath))
+		if err != nil {
+			return true, err
+		}
+		c.gens = g
+	}
+	// the copy was synced when the new ring was closed
+	atomic.StoreInt64(&c.unsynced, 0)
+	seq := atomic.LoadUint64(&c.seq)
+	atomic.StoreUint64(&c.durableSeq, seq)
+	c.publishTrim(atomic.LoadUint64(&c.trimSeq))
+	c.publishCommit(seq)
+	return true, nil
+}
+
+// switchLayout replaces the shards with ones opened for the geometry in opts
+// and loads the position recorded next to them. The cache must be paused.
+func (c *RingBufferCache) switchLayout(opts CacheOptions, shards []*shard) error {
+	m, gen, err := loadMeta(c.metaPath)
+	if err != nil && !c.readOnly {
+		closeShards(shards)
+		return fmt.Errorf("load meta: %w", err)
+	}
+	c.layoutMu.Lock()
+	old := c.shards
+	c.shards = shards
+	c.size = opts.MaxIDAlloc - opts.MinIDAlloc + 1
+	c.minIDAlloc = opts.MinIDAlloc
+	c.maxIDAlloc = uint64(opts.MaxIDAlloc)
+	c.options.MinIDAlloc = opts.MinIDAlloc
+	c.options.MaxIDAlloc = opts.MaxIDAlloc
+	c.options.ShardCount = opts.ShardCount
+	if err == nil {
+		c.metaGen = gen
+		c.setMeta(m)
+	} else {
+		c.setMeta(c.positionAt(0)) // as in attachReader
+	}
+	c.refreshShared()
+	c.layoutMu.Unlock()
+	return closeShards(old)
+}
+
+// pause waits for the running operations to finish and holds new ones off
+// until resume. Only one pause is active at a time.
+func (c *RingBufferCache) pause() error {
+	c.closeMu.Lock()
+	for c.resizing && !c.closed {
+		c.drained.Wait()
+	}
+	if c.closed {
+		c.closeMu.Unlock()
+		return ErrClosed
+	}
+	c.resizing = true
+	for c.inflight > 0 {
+		c.drained.Wait()
+	}
+	c.closeMu.Unlock()
+	if c.prefetcher != nil {
+		c.prefetcher.pause() // workers read the shards without entering
+	}
+	return nil
+}
+
+// resume lets the operations held off by pause continue.
+func (c *RingBufferCache) resume() {
+	if c.prefetcher != nil {
+		c.prefetcher.start()
+	}
+	c.closeMu.Lock()
+	c.resizing = false
+	c.drained.Broadcast()
+	c.closeMu.Unlock()
+}
+
+// swapWait bounds how long a reader waits for a writer's swap to finish.
+const swapWait = 5 * time.Second
+
+// waitLayout returns the layout epoch in h once no swap is in progress.
+func waitLayout(h *sharedHeader) (uint32, error) {
+	w := h.word32(sharedEpoch)
+	deadline := time.Now().Add(swapWait)
+	for {
+		epoch := atomic.LoadUint32(w)
+		if epoch%2 == 0 {
+			return epoch, nil
+		}
+		if time.Now().After(deadline) {
+			return 0, fmt.Errorf("layout change of the cache did not finish")
+		}
+		time.Sleep(sharedPoll)
+	}
+}
+
+// layoutEpochAt returns the layout epoch of the cache at base, 0 when no
+// writer ever created the shared header.
+func layoutEpochAt(base string) (uint32, error) {
+	h, err := mapShared(sharedPath(base), false)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("shared header: %w", err)
+	}
+	defer h.close()
+	return waitLayout(h)
+}
+
+// layoutStale reports whether the writer replaced the shard files since a
+// read-only cache opened them. Called with closeMu held.
+func (c *RingBufferCache) layoutStale() bool {
+	return c.readOnly && c.shared != nil &&
+		atomic.LoadUint32(c.shared.word32(sharedEpoch)) != atomic.LoadUint32(&c.layoutEpoch)
+}
+
+// reloadLayout reopens the shard files of a read-only cache after the writer
+// resized the ring. Only the geometry may change; a cache migrated to
+// another layout must be reopened.
+func (c *RingBufferCache) reloadLayout() error {
+	epoch, err := waitLayout(c.shared)
+	if err != nil {
+		return err
+	}
+	cfg, err := readConfig(c.basePath + ".cfg")
+	if err != nil {
+		return err
+	}
+	c.layoutMu.RLock()
+	old := c.options
+	c.layoutMu.RUnlock()
+	opts := old
+	if err := cfg.apply(&opts); err != nil {
+		return fmt.Errorf("config %s.cfg: %w", c.basePath, err)
+	}
+	for _, f := range newPersistedConfig(old).diff(newPersistedConfig(opts)) {
+		switch f.Field {
+		case "MinIDAlloc", "MaxIDAlloc", "ShardCount":
+		default:
+			return fmt.Errorf("layout of %s changed (%s); reopen the cache", c.basePath, f.Field)
+		}
+	}
+	shards, err := openShards(c.basePath, opts, opts.MaxIDAlloc-opts.MinIDAlloc+1, c.diskRec, true)
+	if err != nil {
+		if atomic.LoadUint32(c.shared.word32(sharedEpoch)) != epoch {
+			return nil // swapped again meanwhile; retried by enter
+		}
+		return err
+	}
+	if err := c.pause(); err != nil {
+		closeShards(shards)
+		return err
+	}
+	defer c.resume()
+	if atomic.LoadUint32(&c.layoutEpoch) == epoch {
+		return closeShards(shards) // another operation reloaded first
+	}
+	err = c.switchLayout(opts, shards)
+	atomic.StoreUint32(&c.layoutEpoch, epoch)
+	if err != nil {
+		return err
+	}
+	return c.reloadCursors()
+}
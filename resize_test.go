@@ -0,0 +1,314 @@
+package archive
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func writeN(t *testing.T, c *RingBufferCache, from, to int) {
+	t.Helper()
+	for i := from; i <= to; i++ {
+		if _, err := c.WriteHead([]byte(fmt.Sprintf("r%03d", i)), false); err != nil {
+			t.Fatalf("WriteHead %d: %v", i, err)
+		}
+	}
+}
+
+func checkSeqs(t *testing.T, c *RingBufferCache, from, to uint64) {
+	t.Helper()
+	for seq := from; seq <= to; seq++ {
+		got, err := c.ReadSeq(seq)
+		if err != nil || string(got) != fmt.Sprintf("r%03d", seq) {
+			t.Fatalf("ReadSeq(%d) = %q, %v", seq, got, err)
+		}
+	}
+}
+
+// checkIDs verifies that each ID holds the record writeN wrote as number i.
+func checkIDs(t *testing.T, c *RingBufferCache, want map[int64]int) {
+	t.Helper()
+	for id, i := range want {
+		got, err := c.Read(id)
+		if err != nil || string(got) != fmt.Sprintf("r%03d", i) {
+			t.Fatalf("Read(%d) = %q, %v, want r%03d", id, got, err, i)
+		}
+	}
+}
+
+func TestResize(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxIDAlloc = 8
+	opts.Sequenced = true
+	cache, base := newTestCacheWithOpts(t, 8, 4, opts)
+	writeN(t, cache, 1, 12)
+
+	if err := cache.Resize(1, 16); err != nil {
+		t.Fatalf("grow: %v", err)
+	}
+	if cache.Size() != 16 || cache.Head() != 4 || cache.Tail() != 5 {
+		t.Fatalf("after grow size=%d head=%d tail=%d", cache.Size(), cache.Head(), cache.Tail())
+	}
+	writeN(t, cache, 13, 20) // IDs 5..12, the oldest records first
+	checkIDs(t, cache, map[int64]int{1: 9, 2: 10, 3: 11, 4: 12, 5: 13, 8: 16, 12: 20})
+
+	// no ID of the new range was used: the newest records move
+	if err := cache.Resize(101, 104); err != nil {
+		t.Fatalf("shrink: %v", err)
+	}
+	if cache.Head() != 104 || cache.Tail() != 101 {
+		t.Fatalf("after shrink head=%d tail=%d", cache.Head(), cache.Tail())
+	}
+	checkIDs(t, cache, map[int64]int{101: 17, 102: 18, 103: 19, 104: 20})
+	writeN(t, cache, 21, 21)
+	opts = cache.options
+	if err := cache.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	opts.MaxIDAlloc = 200 // the resized range is taken from .cfg
+	opts.ConfigPolicy = ConfigAdopt
+	reopened, err := NewRingBufferCacheWithOptions(base, opts)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	if reopened.Size() != 4 || reopened.Head() != 101 {
+		t.Fatalf("reopened size=%d head=%d", reopened.Size(), reopened.Head())
+	}
+	checkIDs(t, reopened, map[int64]int{101: 21, 102: 18, 103: 19, 104: 20})
+	if _, err := os.Stat(base + ".migrate.cfg"); !os.IsNotExist(err) {
+		t.Fatalf("migration target left behind: %v", err)
+	}
+}
+
+func TestResizeGrowWrapped(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxIDAlloc = 8
+	opts.Sequenced = true
+	cache, _ := newTestCacheWithOpts(t, 8, 4, opts)
+	defer cache.Close()
+	writeN(t, cache, 1, 12) // IDs 1..4 hold r009..r012, 5..8 hold r005..r008
+	cur, _ := cache.Cursor("app")
+	for i := 5; i <= 10; i++ {
+		rec, err := cur.Next()
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if err := cur.Commit(rec.ID); err != nil {
+			t.Fatalf("Commit: %v", err)
+		}
+	}
+
+	if err := cache.Resize(1, 16); err != nil {
+		t.Fatalf("Resize: %v", err)
+	}
+	checkIDs(t, cache, map[int64]int{1: 9, 2: 10, 3: 11, 4: 12, 5: 5, 6: 6, 7: 7, 8: 8})
+	if _, payloads := collect(cache.All()); strings.Join(payloads, ",") != "r005,r006,r007,r008,r009,r010,r011,r012" {
+		t.Fatalf("All after grow = %v", payloads)
+	}
+	if cur.Committed() != 2 {
+		t.Fatalf("cursor committed ID %d, want 2", cur.Committed())
+	}
+	if rec, err := cur.Next(); err != nil || rec.ID != 3 || string(rec.Payload) != "r011" {
+		t.Fatalf("cursor after grow: %+v, %v", rec, err)
+	}
+	if got, err := cache.ReadSeq(cache.Seq()); err != nil || string(got) != "r012" {
+		t.Fatalf("ReadSeq(Seq()) = %q, %v", got, err)
+	}
+}
+
+func TestResizeKeepsWrittenIDs(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxIDAlloc = 16
+	cache, _ := newTestCacheWithOpts(t, 16, 4, opts)
+	defer cache.Close()
+	want := make(map[int64]int)
+	for id := int64(1); id <= 16; id++ {
+		if err := cache.Write(id, []byte(fmt.Sprintf("r%03d", 100+id)), false); err != nil {
+			t.Fatalf("Write(%d): %v", id, err)
+		}
+		want[id] = int(100 + id)
+	}
+
+	if err := cache.Resize(1, 32); err != nil {
+		t.Fatalf("grow: %v", err)
+	}
+	checkIDs(t, cache, want)
+	if err := cache.Resize(1, 8); err != nil {
+		t.Fatalf("shrink: %v", err)
+	}
+	for id := int64(9); id <= 16; id++ {
+		delete(want, id) // no free slot left for them
+	}
+	checkIDs(t, cache, want)
+
+	// Write(id) over a ring WriteHead already wrapped
+	writeN(t, cache, 1, 10)
+	if err := cache.Write(4, []byte("w004"), false); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := cache.Resize(1, 12); err != nil {
+		t.Fatalf("grow: %v", err)
+	}
+	checkIDs(t, cache, map[int64]int{1: 9, 2: 10, 3: 3, 5: 5, 8: 8})
+	if got, err := cache.Read(4); err != nil || string(got) != "w004" {
+		t.Fatalf("Read(4) = %q, %v", got, err)
+	}
+}
+
+func TestResizeInvalid(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxIDAlloc = 8
+	cache, base := newTestCacheWithOpts(t, 8, 4, opts)
+	defer cache.Close()
+	if err := cache.Resize(8, 8); err == nil {
+		t.Fatalf("empty range accepted")
+	}
+	if err := cache.Resize(1, 8); err != nil {
+		t.Fatalf("same range: %v", err)
+	}
+	reader, err := OpenReadOnly(base, DefaultOptions())
+	if err != nil {
+		t.Fatalf("OpenReadOnly: %v", err)
+	}
+	defer reader.Close()
+	if err := reader.Resize(1, 16); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("reader Resize: %v", err)
+	}
+}
+
+func TestResizeReader(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxIDAlloc = 8
+	opts.Sequenced = true
+	cache, base := newTestCacheWithOpts(t, 8, 4, opts)
+	defer cache.Close()
+	writeN(t, cache, 1, 6)
+
+	ropts := DefaultOptions()
+	ropts.UseMmap = true
+	reader, err := OpenReadOnly(base, ropts)
+	if err != nil {
+		t.Fatalf("OpenReadOnly: %v", err)
+	}
+	defer reader.Close()
+	checkSeqs(t, reader, 1, 6)
+
+	if err := cache.Resize(1, 32); err != nil {
+		t.Fatalf("Resize: %v", err)
+	}
+	writeN(t, cache, 7, 20)
+	checkSeqs(t, reader, 1, 20) // reopens the shards first
+	if reader.Size() != 32 || reader.Head() != 20 {
+		t.Fatalf("reader size=%d head=%d", reader.Size(), reader.Head())
+	}
+	if ids, _ := collect(reader.All()); len(ids) != 20 || ids[19] != 20 {
+		t.Fatalf("reader All = %v", ids)
+	}
+}
+
+func TestResizeConcurrent(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Sequenced = true
+	opts.MaxIDAlloc = 64
+	cache, base := newTestCacheWithOpts(t, 64, 4, opts)
+	cache.options.PrefetchSize = 4
+	cache.prefetcher = cache.startPrefetcher()
+	defer cache.Close()
+	reader, err := OpenReadOnly(base, DefaultOptions())
+	if err != nil {
+		t.Fatalf("OpenReadOnly: %v", err)
+	}
+	defer reader.Close()
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	errs := make(chan error, 4)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1; i <= 400; i++ {
+			if _, err := cache.WriteHead([]byte(fmt.Sprintf("r%03d", i%1000)), false); err != nil {
+				errs <- fmt.Errorf("WriteHead %d: %w", i, err)
+				return
+			}
+		}
+	}()
+	for _, c := range []*RingBufferCache{cache, reader} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				seq := c.Seq()
+				if seq == 0 {
+					continue
+				}
+				got, err := c.ReadSeq(seq)
+				if errors.Is(err, ErrOverwritten) {
+					continue // lapped by the writer
+				}
+				if err != nil || string(got) != fmt.Sprintf("r%03d", seq%1000) {
+					errs <- fmt.Errorf("ReadSeq(%d) = %q, %v", seq, got, err)
+					return
+				}
+			}
+		}()
+	}
+	for i, size := range []int64{16, 128, 32, 256} {
+		if err := cache.Resize(int64(i)*1000+1, int64(i)*1000+size); err != nil {
+			t.Fatalf("Resize to %d: %v", size, err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if cache.Size() != 256 || cache.Head() != 3000+int64(cache.Seq()-1)%256+1 {
+		t.Fatalf("size=%d head=%d seq=%d", cache.Size(), cache.Head(), cache.Seq())
+	}
+}
+
+func TestResizeCrashDuringSwap(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxIDAlloc = 8
+	opts.Sequenced = true
+	cache, base := newTestCacheWithOpts(t, 8, 4, opts)
+	writeN(t, cache, 1, 10)
+
+	// stop right after the plan was written, before any rename
+	grown := cache.options
+	grown.MaxIDAlloc = 16
+	plan, err := buildMigration(cache, base, grown, true)
+	if err != nil {
+		t.Fatalf("buildMigration: %v", err)
+	}
+	data, _ := json.Marshal(plan)
+	if err := os.WriteFile(swapPlanPath(base), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts = cache.options
+	opts.ConfigPolicy = ConfigAdopt
+	crash(cache)
+
+	reopened, err := NewRingBufferCacheWithOptions(base, opts)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	if reopened.Size() != 16 || reopened.Head() != 2 {
+		t.Fatalf("after replay size=%d head=%d", reopened.Size(), reopened.Head())
+	}
+	checkIDs(t, reopened, map[int64]int{1: 9, 2: 10, 3: 3, 8: 8})
+}
@@ -0,0 +1,141 @@
+package archive
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// RetentionPolicy expires records before the ring overwrites them. Expired
+// records are cut off by moving Tail() forward; they fail to read with
+// ErrExpired and are skipped by Subscribe, cursors and time lookups. The
+// trimmed range is persisted in .meta, so it survives restarts.
+type RetentionPolicy struct {
+	// MaxAge expires records written longer ago than this. It requires a
+	// Timestamped cache; reads check the record time directly, so a record
+	// is never returned past MaxAge even between background passes. The
+	// writer publishes its MaxAge in the shared header, and read-only
+	// processes enforce that value instead of their own.
+	MaxAge time.Duration
+	// MaxRecords keeps at most this many of the newest slots readable
+	// (0 = the full capacity). In VariableLength mode a record spanning
+	// several slots counts once per slot.
+	MaxRecords int64
+	// Interval is the period of the background pass (default 1s).
+	Interval time.Duration
+}
+
+const defaultRetentionInterval = time.Second
+
+func (p RetentionPolicy) enabled() bool { return p.MaxAge > 0 || p.MaxRecords > 0 }
+
+// startRetention launches the background retention pass; it exits when the
+// cache is closed.
+func (c *RingBufferCache) startRetention() {
+	p := c.options.Retention
+	if !p.enabled() {
+		return
+	}
+	interval := p.Interval
+	if interval <= 0 {
+		interval = defaultRetentionInterval
+	}
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-c.done:
+				return
+			case <-t.C:
+			}
+			if err := c.enforceRetention(); err != nil && err != ErrClosed {
+				atomic.AddUint64(&c.trimErrors, 1)
+			}
+		}
+	}()
+}
+
+// enforceRetention trims every record the policy no longer allows.
+func (c *RingBufferCache) enforceRetention() error {
+	if err := c.enter(); err != nil {
+		return err
+	}
+	defer c.exit()
+
+	p := c.options.Retention
+	commit := atomic.LoadUint64(&c.commitSeq)
+	target := atomic.LoadUint64(&c.trimSeq)
+	if p.MaxRecords > 0 && commit > uint64(p.MaxRecords) {
+		target = max(target, commit-uint64(p.MaxRecords))
+	}
+	if p.MaxAge > 0 {
+		first, err := c.seekTime(c.now().Add(-p.MaxAge).UnixNano())
+		if err != nil {
+			return fmt.Errorf("retention: %w", err)
+		}
+		target = max(target, first-1)
+	}
+	return c.trimTo(min(target, commit))
+}
+
+// trimTo expires every record up to seq and persists the new range.
+func (c *RingBufferCache) trimTo(seq uint64) error {
+	for {
+		cur := atomic.LoadUint64(&c.trimSeq)
+		if seq <= cur {
+			return nil
+		}
+		if atomic.CompareAndSwapUint64(&c.trimSeq, cur, seq) {
+			break
+		}
+	}
+	c.publishTrim(seq)
+	if err := c.saveMeta(c.metaState(false)); err != nil {
+		return fmt.Errorf("save meta: %w", err)
+	}
+	return nil
+}
+
+// Trimmed returns the sequence number of the newest record expired by
+// retention (0 when nothing was trimmed).
+func (c *RingBufferCache) Trimmed() uint64 {
+	return atomic.LoadUint64(&c.trimSeq)
+}
+
+// expiredSeq reports whether the record with sequence seq was trimmed.
+func (c *RingBufferCache) expiredSeq(seq uint64) bool {
+	return seq != 0 && seq <= atomic.LoadUint64(&c.trimSeq)
+}
+
+// maxAge returns the MaxAge in force: for a read-only cache the one of the
+// writer, published in the shared header.
+func (c *RingBufferCache) maxAge() time.Duration {
+	if c.readOnly && c.shared != nil {
+		return time.Duration(atomic.LoadUint64(c.shared.word(sharedMaxAge)))
+	}
+	return c.options.Retention.MaxAge
+}
+
+// expiredTime reports whether a record stamped ts is past MaxAge.
+func (c *RingBufferCache) expiredTime(ts int64) bool {
+	maxAge := c.maxAge()
+	return maxAge > 0 && ts != 0 && ts < c.now().Add(-maxAge).UnixNano()
+}
+
+// checkExpired returns an error wrapping ErrExpired when the record with
+// sequence seq and first-slot header h, read from offset of s, must not be
+// returned.
+func (c *RingBufferCache) checkExpired(id int64, seq uint64, h slotHeader, s *shard, offset int64) error {
+	var err error
+	switch {
+	case c.expiredSeq(seq):
+		err = fmt.Errorf("%w: seq %d was trimmed", ErrExpired, seq)
+	case c.expiredTime(h.ts):
+		age := c.now().Sub(c.recordTime(h)).Round(time.Second)
+		err = fmt.Errorf("%w: written %s ago", ErrExpired, age)
+	default:
+		return nil
+	}
+	return c.recordErr(id, s, offset, err)
+}
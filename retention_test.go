@@ -0,0 +1,142 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRetentionMaxRecords(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxIDAlloc = 10
+	opts.Sequenced = true
+	opts.Retention = RetentionPolicy{MaxRecords: 4, Interval: time.Hour}
+	cache, base := newTestCacheWithOpts(t, 10, 4, opts)
+	for i := 0; i < 7; i++ {
+		cache.WriteHead([]byte(fmt.Sprintf("r%03d", i)), false)
+	}
+	if err := cache.enforceRetention(); err != nil {
+		t.Fatalf("enforceRetention: %v", err)
+	}
+	if cache.Tail() != 4 || cache.Head() != 7 || cache.Trimmed() != 3 {
+		t.Fatalf("tail=%d head=%d trimmed=%d", cache.Tail(), cache.Head(), cache.Trimmed())
+	}
+
+	_, err := cache.Read(2)
+	var re *RecordError
+	if !errors.Is(err, ErrExpired) || !errors.As(err, &re) || re.ID != 2 {
+		t.Fatalf("Read of trimmed id: %v", err)
+	}
+	if _, err := cache.ReadSeq(3); !errors.Is(err, ErrExpired) {
+		t.Fatalf("ReadSeq of trimmed seq: %v", err)
+	}
+	if got, err := cache.Read(4); err != nil || string(got) != "r003" {
+		t.Fatalf("Read(4) = %q, %v", got, err)
+	}
+
+	cur, _ := cache.Cursor("c")
+	if rec, err := cur.Next(); err != nil || rec.ID != 4 {
+		t.Fatalf("cursor started at %d, %v", rec.ID, err)
+	}
+	cache.Close()
+
+	reopened := reopenTestCache(t, base, 4)
+	defer reopened.Close()
+	if reopened.Tail() != 4 || reopened.Trimmed() != 3 {
+		t.Fatalf("trim lost on reopen: tail=%d trimmed=%d", reopened.Tail(), reopened.Trimmed())
+	}
+	if _, err := reopened.Read(1); !errors.Is(err, ErrExpired) {
+		t.Fatalf("Read after reopen: %v", err)
+	}
+}
+
+func TestRetentionMaxAge(t *testing.T) {
+	cache, _, now := newTimedCache(t, 8, false)
+	defer cache.Close()
+	cache.options.Retention = RetentionPolicy{MaxAge: 10 * time.Minute}
+	start := *now
+	for i := 0; i < 5; i++ {
+		*now = start.Add(time.Duration(i) * 5 * time.Minute)
+		cache.WriteHead([]byte(fmt.Sprintf("r%03d", i)), false)
+	}
+
+	// records 1 and 2 are past MaxAge before any retention pass ran
+	if _, err := cache.Read(2); !errors.Is(err, ErrExpired) {
+		t.Fatalf("Read of aged record: %v", err)
+	}
+	if got, err := cache.Read(3); err != nil || string(got) != "r002" {
+		t.Fatalf("Read(3) = %q, %v", got, err)
+	}
+	if id, err := cache.SeekTime(start); err != nil || id != 3 {
+		t.Fatalf("SeekTime skipped to %d, %v", id, err)
+	}
+	recs, err := cache.RangeByTime(start, start.Add(time.Hour))
+	if err != nil || len(recs) != 3 || recs[0].ID != 3 {
+		t.Fatalf("RangeByTime: %+v, %v", recs, err)
+	}
+
+	if err := cache.enforceRetention(); err != nil {
+		t.Fatalf("enforceRetention: %v", err)
+	}
+	if cache.Tail() != 3 {
+		t.Fatalf("tail = %d, want 3", cache.Tail())
+	}
+}
+
+func TestRetentionMaxAgeReader(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxIDAlloc = 8
+	opts.Timestamped = true
+	opts.Retention = RetentionPolicy{MaxAge: 10 * time.Minute, Interval: time.Hour}
+	cache, base := newTestCacheWithOpts(t, 8, 4, opts)
+	defer cache.Close()
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return start }
+	cache.WriteHead([]byte("r000"), false)
+
+	// the reader has no retention of its own
+	reader, err := OpenReadOnly(base, DefaultOptions())
+	if err != nil {
+		t.Fatalf("OpenReadOnly: %v", err)
+	}
+	defer reader.Close()
+	now := start.Add(5 * time.Minute)
+	reader.now = func() time.Time { return now }
+	if got, err := reader.Read(1); err != nil || string(got) != "r000" {
+		t.Fatalf("Read before MaxAge = %q, %v", got, err)
+	}
+	now = start.Add(20 * time.Minute)
+	if _, err := reader.Read(1); !errors.Is(err, ErrExpired) {
+		t.Fatalf("Read past MaxAge: %v", err)
+	}
+}
+
+func TestRetentionSubscribeSkipsExpired(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxIDAlloc = 8
+	opts.Retention = RetentionPolicy{MaxRecords: 2, Interval: time.Hour}
+	cache, _ := newTestCacheWithOpts(t, 8, 4, opts)
+	defer cache.Close()
+	for i := 0; i < 5; i++ {
+		cache.WriteHead([]byte(fmt.Sprintf("r%03d", i)), false)
+	}
+	cache.enforceRetention()
+
+	rec, after, ok := cache.readFrom(1)
+	if !ok || rec.Err != nil || rec.ID != 4 || after != 5 {
+		t.Fatalf("readFrom(1) = %+v, %d, %v", rec, after, ok)
+	}
+}
+
+func TestRetentionMaxAgeRequiresTimestamps(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Retention = RetentionPolicy{MaxAge: time.Hour}
+	opts.UseMmap = false
+	opts.ShardCount = 1
+	opts.RecordSize = 4
+	opts.MaxIDAlloc = 8
+	if _, err := NewRingBufferCacheWithOptions(t.TempDir()+"/cache.data", opts); err == nil {
+		t.Fatalf("expected error for MaxAge without Timestamped")
+	}
+}
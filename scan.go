@@ -0,0 +1,195 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"sync/atomic"
+)
+
+// ScanAction tells a Scanner what to do with a record it cannot return.
+type ScanAction int
+
+const (
+	// ScanSkip leaves the record out and continues.
+	ScanSkip ScanAction = iota
+	// ScanYield yields the record's ID with a nil payload; Scanner.Err
+	// returns the reason until the next record is yielded.
+	ScanYield
+	// ScanStop ends the scan; Scanner.Err returns the reason afterwards.
+	ScanStop
+)
+
+// ScanPolicy configures how a Scanner treats unreadable records. The zero
+// value skips them all.
+type ScanPolicy struct {
+	Corrupt ScanAction // records that fail to read, e.g. with ErrCorrupted
+	Deleted ScanAction // records removed with Delete or DeleteRange
+}
+
+// Scanner iterates over the live window of a cache, from Tail() to Head(),
+// following the logical order across the wrap boundary. Each record is read
+// on its own, so a scan holds no lock between records and stops with
+// ErrClosed when the cache is closed. Records expired by retention are never
+// yielded. A Scanner must not be used by several goroutines at once.
+type Scanner struct {
+	c   *RingBufferCache
+	p   ScanPolicy
+	err error
+}
+
+// Scan returns a Scanner that treats unreadable records as p says.
+func (c *RingBufferCache) Scan(p ScanPolicy) *Scanner {
+	return &Scanner{c: c, p: p}
+}
+
+// All iterates over every live record, oldest first, skipping unreadable
+// ones. Use Scan for a different ScanPolicy.
+func (c *RingBufferCache) All() iter.Seq2[int64, []byte] {
+	return c.Scan(ScanPolicy{}).All()
+}
+
+// Range iterates over the live records from ID from to ID to in logical
+// order, skipping unreadable ones. Use Scan for a different ScanPolicy.
+func (c *RingBufferCache) Range(from, to int64) iter.Seq2[int64, []byte] {
+	return c.Scan(ScanPolicy{}).Range(from, to)
+}
+
+// Backward iterates over every live record, newest first, skipping
+// unreadable ones. Use Scan for a different ScanPolicy.
+func (c *RingBufferCache) Backward() iter.Seq2[int64, []byte] {
+	return c.Scan(ScanPolicy{}).Backward()
+}
+
+// Err returns the error that stopped the last scan, or, during a scan with
+// ScanYield, the error of the record just yielded with a nil payload.
+func (s *Scanner) Err() error { return s.err }
+
+// All iterates over every live record, oldest first.
+func (s *Scanner) All() iter.Seq2[int64, []byte] {
+	return s.forward(func() (uint64, uint64, error) {
+		return s.c.oldestSeq(), atomic.LoadUint64(&s.c.commitSeq), nil
+	})
+}
+
+// Range iterates over the live records from ID from to ID to in logical
+// order. A record that starts at to is yielded whole. Both IDs must lie in
+// the live window; if from is newer than to nothing is yielded.
+func (s *Scanner) Range(from, to int64) iter.Seq2[int64, []byte] {
+	return s.forward(func() (uint64, uint64, error) {
+		lo, err := s.c.liveSeq(from)
+		if err != nil {
+			return 0, 0, err
+		}
+		hi, err := s.c.liveSeq(to)
+		return lo, hi, err
+	})
+}
+
+// Backward iterates over every live record, newest first.
+func (s *Scanner) Backward() iter.Seq2[int64, []byte] {
+	return func(yield func(int64, []byte) bool) {
+		s.err = nil
+		c := s.c
+		c.layoutMu.RLock()
+		c.refreshShared()
+		lo := c.oldestSeq()
+		c.layoutMu.RUnlock()
+		for seq := atomic.LoadUint64(&c.commitSeq); seq >= lo && seq > 0; seq-- {
+			id, _, payload, err := c.scanRead(seq)
+			if errors.Is(err, ErrOverwritten) {
+				return // everything older is gone as well
+			}
+			if !s.handle(id, payload, err, yield) {
+				return
+			}
+		}
+		s.err = nil
+	}
+}
+
+// forward yields the records starting between the sequence numbers returned
+// by bounds, which is evaluated when the iteration starts.
+func (s *Scanner) forward(bounds func() (lo, hi uint64, err error)) iter.Seq2[int64, []byte] {
+	return func(yield func(int64, []byte) bool) {
+		s.err = nil
+		c := s.c
+		c.layoutMu.RLock()
+		c.refreshShared()
+		seq, hi, err := bounds()
+		c.layoutMu.RUnlock()
+		if err != nil {
+			s.err = err
+			return
+		}
+		for seq <= hi {
+			id, slots, payload, err := c.scanRead(seq)
+			next := seq + 1
+			switch {
+			case err == nil:
+				next = seq + uint64(slots)
+			case errors.Is(err, ErrOverwritten):
+				// the producer lapped the scan
+				c.layoutMu.RLock()
+				next = max(next, c.oldestSeq())
+				c.layoutMu.RUnlock()
+			}
+			if !s.handle(id, payload, err, yield) {
+				return
+			}
+			seq = next
+		}
+		s.err = nil
+	}
+}
+
+// handle yields one read result according to the policy and reports whether
+// the scan goes on.
+func (s *Scanner) handle(id int64, payload []byte, err error, yield func(int64, []byte) bool) bool {
+	action := s.p.Corrupt
+	switch {
+	case err == nil:
+		s.err = nil
+		return yield(id, payload)
+	case errors.Is(err, ErrClosed):
+		s.err = err
+		return false
+	case errors.Is(err, errContinuation), errors.Is(err, ErrExpired):
+		return true // not the start of a live record
+	case errors.Is(err, ErrDeleted):
+		action = s.p.Deleted
+	}
+	switch action {
+	case ScanYield:
+		s.err = err
+		return yield(id, nil)
+	case ScanStop:
+		s.err = err
+		return false
+	}
+	return true
+}
+
+// scanRead reads the record stored for seq as one operation of its own and
+// returns the number of slots it occupies.
+func (c *RingBufferCache) scanRead(seq uint64) (int64, int64, []byte, error) {
+	if err := c.enter(); err != nil {
+		return c.idForSeq(seq), 0, nil, err
+	}
+	defer c.exit()
+	id, h, payload, err := c.readSeq(seq)
+	return id, c.slotsFor(h.length), payload, err
+}
+
+// liveSeq returns the sequence number of the record at id, which must lie
+// in the committed live window.
+func (c *RingBufferCache) liveSeq(id int64) (uint64, error) {
+	if _, _, err := c.slotAt(id); err != nil {
+		return 0, err
+	}
+	seq := c.ringSeq(id)
+	if seq == 0 || seq < c.oldestSeq() || seq > atomic.LoadUint64(&c.commitSeq) {
+		return 0, fmt.Errorf("%w: id %d is not in the live window", ErrOutOfRange, id)
+	}
+	return seq, nil
+}
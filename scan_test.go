@@ -0,0 +1,93 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"testing"
+)
+
+// newScanCache returns a sequenced 8-slot cache after 11 WriteHead calls, so
+// the live window is seq 4..11 and wraps from ID 8 to ID 1.
+func newScanCache(t *testing.T) (*RingBufferCache, string) {
+	t.Helper()
+	opts := DefaultOptions()
+	opts.MaxIDAlloc = 8
+	opts.Sequenced = true
+	cache, base := newTestCacheWithOpts(t, 8, 4, opts)
+	for i := 1; i <= 11; i++ {
+		cache.WriteHead([]byte(fmt.Sprintf("r%03d", i)), false)
+	}
+	return cache, base
+}
+
+func collect(seq func(func(int64, []byte) bool)) (ids []int64, payloads []string) {
+	for id, p := range seq {
+		ids = append(ids, id)
+		payloads = append(payloads, string(p))
+	}
+	return ids, payloads
+}
+
+func TestScanLogicalOrderAcrossWrap(t *testing.T) {
+	cache, _ := newScanCache(t)
+	defer cache.Close()
+
+	ids, payloads := collect(cache.All())
+	if want := []int64{4, 5, 6, 7, 8, 1, 2, 3}; !slices.Equal(ids, want) {
+		t.Fatalf("All ids = %v, want %v", ids, want)
+	}
+	if payloads[0] != "r004" || payloads[7] != "r011" {
+		t.Fatalf("All payloads = %v", payloads)
+	}
+
+	ids, _ = collect(cache.Backward())
+	if want := []int64{3, 2, 1, 8, 7, 6, 5, 4}; !slices.Equal(ids, want) {
+		t.Fatalf("Backward ids = %v, want %v", ids, want)
+	}
+
+	ids, _ = collect(cache.Range(7, 2))
+	if want := []int64{7, 8, 1, 2}; !slices.Equal(ids, want) {
+		t.Fatalf("Range(7, 2) = %v, want %v", ids, want)
+	}
+	if ids, _ = collect(cache.Range(2, 7)); len(ids) != 0 {
+		t.Fatalf("Range(2, 7) = %v, want nothing", ids)
+	}
+
+	for id := range cache.All() {
+		if id == 6 {
+			break
+		}
+	}
+}
+
+func TestScanPolicy(t *testing.T) {
+	cache, base := newScanCache(t)
+	defer cache.Close()
+	cache.Delete(6)
+	f, _ := os.OpenFile(base, os.O_RDWR, 0)
+	f.WriteAt([]byte{0xFF}, int64(cache.diskRec)+int64(cache.layout.hdrSize)) // ID 2
+	f.Close()
+
+	if ids, _ := collect(cache.All()); !slices.Equal(ids, []int64{4, 5, 7, 8, 1, 3}) {
+		t.Fatalf("default policy = %v", ids)
+	}
+
+	s := cache.Scan(ScanPolicy{Deleted: ScanYield, Corrupt: ScanStop})
+	var got []int64
+	for id, p := range s.All() {
+		if id == 6 && (p != nil || !errors.Is(s.Err(), ErrDeleted)) {
+			t.Fatalf("deleted record yielded as %q, %v", p, s.Err())
+		}
+		got = append(got, id)
+	}
+	if !slices.Equal(got, []int64{4, 5, 6, 7, 8, 1}) || !errors.Is(s.Err(), ErrCorrupted) {
+		t.Fatalf("scan = %v, err %v", got, s.Err())
+	}
+
+	s = cache.Scan(ScanPolicy{})
+	if ids, _ := collect(s.Range(4, 9)); len(ids) != 0 || !errors.Is(s.Err(), ErrOutOfRange) {
+		t.Fatalf("Range past MaxIDAlloc = %v, %v", ids, s.Err())
+	}
+}
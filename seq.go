@@ -0,0 +1,105 @@
+package archive
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Seq returns the logical write count of the ring: the sequence number of the
+// most recent slot claimed by WriteHead (0 on a fresh cache).
+func (c *RingBufferCache) Seq() uint64 {
+	c.refreshShared()
+	return atomic.LoadUint64(&c.seq)
+}
+
+// idForSeq maps a sequence number (1-based) to the ring ID it is stored at.
+func (c *RingBufferCache) idForSeq(seq uint64) int64 {
+	return c.minIDAlloc + int64((seq-1)%uint64(c.size))
+}
+
+// seqForID returns the sequence number that the ring position currently
+// assigns to id: the one of the current lap if WriteHead already reached id,
+// otherwise the one of the previous lap. It returns 0 when the slot has never
+// been claimed by WriteHead or the cache is not sequenced.
+func (c *RingBufferCache) seqForID(id int64) uint64 {
+	if c.layout.seqOff == 0 {
+		return 0
+	}
+	return c.ringSeq(id)
+}
+
+// ringSeq is seqForID without the layout check; the in-memory counter is
+// maintained for every cache.
+func (c *RingBufferCache) ringSeq(id int64) uint64 {
+	cur := atomic.LoadUint64(&c.seq)
+	if cur == 0 {
+		return 0
+	}
+	size := uint64(c.size)
+	pos := uint64(id - c.minIDAlloc)
+	headPos := (cur - 1) % size
+	seq := cur - headPos + pos
+	if pos > headPos {
+		if seq <= size {
+			return 0
+		}
+		seq -= size
+	}
+	return seq
+}
+
+// ReadSeq reads the record with the given sequence number. Unlike Read, it
+// verifies that the slot still holds that record and returns an error
+// wrapping ErrOverwritten when the producer has since reused the slot.
+func (c *RingBufferCache) ReadSeq(seq uint64) ([]byte, error) {
+	if c.layout.seqOff == 0 {
+		return nil, fmt.Errorf("cache is not sequenced")
+	}
+	if err := c.enter(); err != nil {
+		return nil, err
+	}
+	defer c.exit()
+	cur := atomic.LoadUint64(&c.seq)
+	if seq == 0 || seq > cur {
+		return nil, fmt.Errorf("%w: seq %d not written yet (latest %d)", ErrOutOfRange, seq, cur)
+	}
+	_, _, out, err := c.readSeq(seq)
+	return out, err
+}
+
+// readSeq reads the record stored for seq. Overwrites are detected from the
+// slot header when the cache is sequenced and from the write counter
+// otherwise, so it also serves unsequenced caches. The header of the record's
+// first slot is returned alongside the payload.
+func (c *RingBufferCache) readSeq(seq uint64) (int64, slotHeader, []byte, error) {
+	id := c.idForSeq(seq)
+	size := uint64(c.size)
+	shard, offset, err := c.slotAt(id)
+	if err != nil {
+		return id, slotHeader{}, nil, err
+	}
+	if cur := atomic.LoadUint64(&c.seq); cur-seq >= size {
+		err := fmt.Errorf("%w: seq %d (latest %d)", ErrOverwritten, seq, cur)
+		return id, slotHeader{}, nil, c.recordErr(id, shard, offset, err)
+	}
+
+	h, out, err := c.readRecord(shard, offset, id)
+	switch {
+	case h.seq > seq:
+		err := fmt.Errorf("%w: seq %d, slot now holds seq %d", ErrOverwritten, seq, h.seq)
+		return id, h, nil, c.recordErr(id, shard, offset, err)
+	case atomic.LoadUint64(&c.seq)-seq >= size:
+		// slot reclaimed while we were reading it
+		err := fmt.Errorf("%w: seq %d", ErrOverwritten, seq)
+		return id, h, nil, c.recordErr(id, shard, offset, err)
+	case err != nil:
+		return id, h, nil, err
+	case c.layout.seqOff > 0 && h.seq != seq:
+		err := fmt.Errorf("%w: seq %d not found, slot holds seq %d", ErrCorrupted, seq, h.seq)
+		return id, h, nil, c.recordErr(id, shard, offset, err)
+	}
+	if err := c.checkExpired(id, seq, h, shard, offset); err != nil {
+		return id, h, nil, err
+	}
+	return id, h, out, nil
+}
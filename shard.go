@@ -1,6 +1,11 @@
 package archive
 
-import "os"
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
 
 // shard merepresentasikan satu bagian dari cache yang di-shard.
 //
@@ -15,9 +20,109 @@ import "os"
 // Catatan: definisi tetap tidak diekspor untuk menjaga enkapsulasi; API publik
 // berinteraksi melalui RingBufferCache.
 type shard struct {
-	file     *os.File // descriptor file fisik
-	mmap     []byte   // region memory-map (nil bila mmap dimatikan)
-	filePath string   // path file pada disk
-	size     int64    // jumlah record dalam shard
-	offset   int64    // ID offset (basis 1) untuk shard ini
+	file     *os.File    // descriptor file fisik
+	mmap     []byte      // region memory-map (nil bila mmap dimatikan)
+	filePath string      // path file pada disk
+	size     int64       // jumlah record dalam shard
+	offset   int64       // ID offset (basis 1) untuk shard ini
+	index    int         // posisi shard dalam RingBufferCache.shards
+	group    groupCommit // fsync bersama untuk mode GroupCommit
+}
+
+// shardPath mengembalikan path file untuk shard ke-i. Cache single-shard
+// memakai basePath apa adanya, multi-shard menambahkan sufiks ".i".
+func shardPath(basePath string, count, i int) string {
+	if count > 1 {
+		return fmt.Sprintf("%s.%d", basePath, i)
+	}
+	return basePath
+}
+
+// openShards membuka (dan bagi writer membuat serta mengatur ukuran) file
+// shard untuk ring berisi size slot.
+func openShards(basePath string, opts CacheOptions, size int64, diskRec int, readOnly bool) ([]*shard, error) {
+	// Hitung ukuran shard default bila multi-shard
+	shardSize := size
+	if opts.ShardCount > 1 {
+		shardSize = size / int64(opts.ShardCount)
+		if size%int64(opts.ShardCount) != 0 {
+			shardSize++ // round-up
+		}
+	}
+
+	// Inisialisasi shards
+	shards := make([]*shard, 0, opts.ShardCount)
+	var offset int64
+
+	for i := 0; i < opts.ShardCount; i++ {
+		currentShardSize := shardSize
+		if i == opts.ShardCount-1 {
+			currentShardSize = size - offset // shard terakhir mungkin lebih kecil
+		}
+
+		shardPath := shardPath(basePath, opts.ShardCount, i)
+
+		flags, prot := os.O_RDWR|os.O_CREATE, unix.PROT_READ|unix.PROT_WRITE
+		if readOnly {
+			flags, prot = os.O_RDONLY, unix.PROT_READ
+		}
+		f, err := os.OpenFile(shardPath, flags, 0o666)
+		if err != nil {
+			closeShards(shards) // cleanup opened shards
+			return nil, &ShardError{Op: "open", Shard: i, Path: shardPath, Err: err}
+		}
+
+		diskSize := currentShardSize * int64(diskRec)
+		op, err := "truncate", error(nil)
+		if readOnly {
+			op, err = "stat", checkShardSize(f, diskSize)
+		} else {
+			err = f.Truncate(diskSize)
+		}
+		if err != nil {
+			f.Close()
+			closeShards(shards)
+			return nil, &ShardError{Op: op, Shard: i, Path: shardPath, Err: err}
+		}
+
+		s := &shard{
+			file:     f,
+			filePath: shardPath,
+			size:     currentShardSize,
+			offset:   offset,
+			index:    i,
+		}
+
+		if opts.UseMmap {
+			mmap, err := unix.Mmap(int(f.Fd()), 0, int(diskSize), prot, unix.MAP_SHARED)
+			if err != nil {
+				f.Close()
+				closeShards(shards)
+				return nil, &ShardError{Op: "mmap", Shard: i, Path: shardPath, Err: err}
+			}
+			s.mmap = mmap
+		}
+
+		shards = append(shards, s)
+		offset += currentShardSize
+	}
+	return shards, nil
+}
+
+// closeShards melepas mmap dan menutup file semua shard, lalu mengembalikan
+// error pertama.
+func closeShards(shards []*shard) error {
+	var firstErr error
+	for i, s := range shards {
+		if s.mmap != nil {
+			if err := unix.Munmap(s.mmap); err != nil && firstErr == nil {
+				firstErr = &ShardError{Op: "munmap", Shard: i, Path: s.filePath, Err: err}
+			}
+			s.mmap = nil
+		}
+		if err := s.file.Close(); err != nil && firstErr == nil {
+			firstErr = &ShardError{Op: "close", Shard: i, Path: s.filePath, Err: err}
+		}
+	}
+	return firstErr
 }
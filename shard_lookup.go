@@ -8,7 +8,7 @@ import "fmt"
 // bila ID di luar rentang 1..c.size.
 func (c *RingBufferCache) findShard(id int64) (*shard, int64, error) {
 	if id < 1 || id > c.size {
-		return nil, 0, fmt.Errorf("id out of range: %d (max: %d)", id, c.size)
+		return nil, 0, fmt.Errorf("%w: relative id %d (max %d)", ErrOutOfRange, id, c.size)
 	}
 
 	// Cari shard yang intervalnya mencakup id.
@@ -18,5 +18,5 @@ func (c *RingBufferCache) findShard(id int64) (*shard, int64, error) {
 		}
 	}
 	// Seharusnya tidak terjadi.
-	return nil, 0, fmt.Errorf("id tidak ditemukan dalam shard manapun: %d", id)
+	return nil, 0, fmt.Errorf("%w: relative id %d is not in any shard", ErrOutOfRange, id)
 }
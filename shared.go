@@ -0,0 +1,206 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// The writer mirrors its position counters into <base>.head, a small file
+// that every process maps MAP_SHARED. Read-only processes load them before
+// each operation, so they follow Head() and Tail() without re-reading .meta,
+// which is only rewritten on syncs. The file is never synced: after a
+// restart the writer republishes the position it recovered.
+//
+//	0..7   : magic "RBHEAD01"
+//	8..15  : uint64 seq (last claimed slot)
+//	16..23 : uint64 commitSeq (last committed slot)
+//	24..31 : uint64 trimSeq (last slot expired by retention)
+//	32..35 : uint32 notify, bumped on every commit; readers futex-wait on it
+//	36..39 : uint32 number of readers waiting on notify
+//	40..43 : uint32 layout epoch, bumped before and after the shard files are
+//	         replaced (odd while a swap is in progress; see Resize)
+//	44..47 : reserved
+//	48..55 : int64 Retention.MaxAge of the writer in nanoseconds, enforced by
+//	         readers on every read
+//	56..63 : reserved
+//
+// Words are accessed with atomic loads and stores on the mapping.
+
+const (
+	sharedMagic   = "RBHEAD01"
+	sharedSize    = 64
+	sharedSeq     = 8
+	sharedCommit  = 16
+	sharedTrim    = 24
+	sharedNotify  = 32
+	sharedWaiters = 36
+	sharedEpoch   = 40
+	sharedMaxAge  = 48
+)
+
+type sharedHeader struct {
+	file     *os.File
+	mem      []byte
+	writable bool // false for readers without write permission on the file
+}
+
+func sharedPath(base string) string { return base + ".head" }
+
+// mapShared maps the shared header at path, creating it for the writer. A
+// reader maps it writable when the file permissions allow, so that it can
+// register in the waiter count, and read-only otherwise; it never changes
+// the position words.
+func mapShared(path string, create bool) (*sharedHeader, error) {
+	flags := os.O_RDWR
+	if create {
+		flags |= os.O_CREATE
+	}
+	writable := true
+	f, err := os.OpenFile(path, flags, 0o644)
+	if !create && errors.Is(err, fs.ErrPermission) {
+		writable = false
+		f, err = os.OpenFile(path, os.O_RDONLY, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if create {
+		err = f.Truncate(sharedSize)
+	} else {
+		err = checkShardSize(f, sharedSize)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	prot := unix.PROT_READ
+	if writable {
+		prot |= unix.PROT_WRITE
+	}
+	mem, err := unix.Mmap(int(f.Fd()), 0, sharedSize, prot, unix.MAP_SHARED)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	h := &sharedHeader{file: f, mem: mem, writable: writable}
+	if create {
+		copy(mem, sharedMagic)
+	} else if string(mem[:len(sharedMagic)]) != sharedMagic {
+		h.close()
+		return nil, fmt.Errorf("%w: bad magic in %s", ErrCorrupted, path)
+	}
+	return h, nil
+}
+
+// word returns the 8-byte aligned word at off.
+func (h *sharedHeader) word(off int) *uint64 {
+	return (*uint64)(unsafe.Pointer(&h.mem[off]))
+}
+
+// word32 returns the 4-byte aligned word at off.
+func (h *sharedHeader) word32(off int) *uint32 {
+	return (*uint32)(unsafe.Pointer(&h.mem[off]))
+}
+
+func (h *sharedHeader) close() error {
+	err := unix.Munmap(h.mem)
+	if cerr := h.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// attachShared creates the writer's shared header and publishes the
+// position established by recovery.
+func (c *RingBufferCache) attachShared() error {
+	h, err := mapShared(sharedPath(c.basePath), true)
+	if err != nil {
+		return fmt.Errorf("shared header: %w", err)
+	}
+	atomic.StoreUint64(h.word(sharedTrim), atomic.LoadUint64(&c.trimSeq))
+	atomic.StoreUint64(h.word(sharedSeq), atomic.LoadUint64(&c.seq))
+	atomic.StoreUint64(h.word(sharedCommit), atomic.LoadUint64(&c.commitSeq))
+	atomic.StoreUint64(h.word(sharedMaxAge), uint64(c.options.Retention.MaxAge))
+	if epoch := h.word32(sharedEpoch); atomic.LoadUint32(epoch)%2 == 1 {
+		atomic.AddUint32(epoch, 1) // the swap of a crashed writer was finished on open
+	}
+	c.shared = h
+	return nil
+}
+
+// publishSeq raises the shared seq to at least seq.
+func (c *RingBufferCache) publishSeq(seq uint64) {
+	if c.shared == nil || c.readOnly {
+		return
+	}
+	w := c.shared.word(sharedSeq)
+	for {
+		cur := atomic.LoadUint64(w)
+		if cur >= seq || atomic.CompareAndSwapUint64(w, cur, seq) {
+			return
+		}
+	}
+}
+
+// publishCommit publishes a commit up to seq and wakes waiting readers. The
+// wake-up syscall is skipped while no reader is registered as a waiter.
+func (c *RingBufferCache) publishCommit(seq uint64) {
+	if c.shared == nil || c.readOnly {
+		return
+	}
+	c.publishSeq(seq)
+	atomic.StoreUint64(c.shared.word(sharedCommit), seq)
+	notify := c.shared.word32(sharedNotify)
+	atomic.AddUint32(notify, 1)
+	if atomic.LoadUint32(c.shared.word32(sharedWaiters)) > 0 {
+		futexWakeAll(notify)
+	}
+}
+
+// publishTrim publishes the trimmed range.
+func (c *RingBufferCache) publishTrim(seq uint64) {
+	if c.shared == nil || c.readOnly {
+		return
+	}
+	atomic.StoreUint64(c.shared.word(sharedTrim), seq)
+}
+
+// attachReader loads the position of a read-only cache: from the shared
+// header when the writer created one, from .meta otherwise.
+func (c *RingBufferCache) attachReader() error {
+	if m, gen, err := loadMeta(c.metaPath); err == nil {
+		c.metaGen = gen
+		c.setMeta(m)
+	} else {
+		c.setMeta(c.positionAt(0))
+	}
+	h, err := mapShared(sharedPath(c.basePath), false)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return nil
+	case err != nil:
+		return fmt.Errorf("shared header: %w", err)
+	}
+	c.shared = h
+	c.refreshShared()
+	return nil
+}
+
+// refreshShared copies the writer's position into a read-only cache.
+func (c *RingBufferCache) refreshShared() {
+	if !c.readOnly || c.shared == nil {
+		return
+	}
+	commit := atomic.LoadUint64(c.shared.word(sharedCommit))
+	seq := max(atomic.LoadUint64(c.shared.word(sharedSeq)), commit)
+	trim := min(atomic.LoadUint64(c.shared.word(sharedTrim)), commit)
+	atomic.StoreUint64(&c.seq, seq)
+	atomic.StoreUint64(&c.commitSeq, commit)
+	atomic.StoreUint64(&c.trimSeq, trim)
+}
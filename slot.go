@@ -0,0 +1,200 @@
+package archive
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Slot layout on disk.
+//
+// Fixed-size caches keep the original layout: a 4-byte IEEE CRC32 followed by
+// exactly RecordSize payload bytes.
+//
+// Variable-length, sequenced and timestamped caches extend the header after
+// the checksum; absent fields take no space:
+//
+//	0..    : checksum over the rest of the header and the used data bytes;
+//	         4 bytes for the CRC32 variants, 8 for xxHash64, none for
+//	         ChecksumNone (see ChecksumAlgo)
+//	+4     : uint32 info (bit 31 = continuation, bit 30 = tombstone,
+//	         bit 29 = written, bits 0..27 = remaining length)
+//	+8     : uint64 sequence number (sequenced caches only)
+//	+8     : int64 write time in Unix nanoseconds (timestamped caches only)
+//	...    : up to RecordSize data bytes
+//
+// The sequence number is the logical write count of the ring at the time the
+// slot was claimed by WriteHead, so a reused slot always carries a larger
+// sequence than the record it replaced.
+//
+// A record longer than RecordSize spans consecutive slots, wrapping from
+// MaxIDAlloc back to MinIDAlloc. The length stored in each slot is the number
+// of record bytes from that slot onward, so the first slot carries the full
+// record length and every slot can be validated on its own.
+//
+// A deleted record is a tombstone slot. The fixed-size layout has no info
+// word, so there a tombstone stores the complement of the checksum over a
+// zeroed payload instead. Without a checksum the info word is always present
+// and its written bit tells a slot that was never written from one that was.
+
+const (
+	infoLenMask      = 1<<28 - 1 // maximum length of a variable-length record
+	flagContinuation = 1 << 31   // slot continues a record started earlier
+	flagTombstone    = 1 << 30   // slot marks a deleted record
+	flagWritten      = 1 << 29   // slot was written (checksum-free layouts)
+)
+
+// errContinuation marks a read that landed in the middle of a multi-slot
+// record instead of on its first slot.
+var errContinuation = errors.New("continuation slot")
+
+// slotLayout describes the header that precedes the data bytes of every slot.
+type slotLayout struct {
+	sum     ChecksumAlgo
+	sumSize int  // checksum width in bytes
+	hasInfo bool // header carries an info word
+	infoOff int  // offset of the info word
+	seqOff  int  // offset of the sequence number (0 = absent)
+	tsOff   int  // offset of the timestamp (0 = absent)
+	hdrSize int  // total header size, checksum included
+}
+
+func newSlotLayout(opts CacheOptions) slotLayout {
+	n := opts.Checksum.size()
+	l := slotLayout{sum: opts.Checksum, sumSize: n, hdrSize: n}
+	if opts.VariableLength || opts.Sequenced || opts.Timestamped || n == 0 {
+		l.hasInfo, l.infoOff = true, l.hdrSize
+		l.hdrSize += 4
+	}
+	if opts.Sequenced {
+		l.seqOff = l.hdrSize
+		l.hdrSize += 8
+	}
+	if opts.Timestamped {
+		l.tsOff = l.hdrSize
+		l.hdrSize += 8
+	}
+	return l
+}
+
+// slotHeader is the decoded header of one slot.
+type slotHeader struct {
+	flags  uint32 // flag bits of the info word
+	length int    // record bytes stored from this slot onward
+	seq    uint64 // sequence number (0 when absent or never sequenced)
+	ts     int64  // write time in Unix nanoseconds (0 when absent)
+}
+
+// slotsFor returns how many slots a payload of n bytes occupies.
+func (c *RingBufferCache) slotsFor(n int) int64 {
+	if n <= c.record {
+		return 1
+	}
+	return int64((n + c.record - 1) / c.record)
+}
+
+// checkPayload validates a payload length against the cache mode and returns
+// the number of slots it occupies.
+func (c *RingBufferCache) checkPayload(n int) (int64, error) {
+	if !c.options.VariableLength {
+		if n != c.record {
+			return 0, fmt.Errorf("%w: got %d bytes, want %d", ErrPayloadSize, n, c.record)
+		}
+		return 1, nil
+	}
+	if n > infoLenMask {
+		return 0, fmt.Errorf("%w: %d bytes exceeds the maximum of %d", ErrPayloadSize, n, infoLenMask)
+	}
+	slots := c.slotsFor(n)
+	if slots > c.size {
+		return 0, fmt.Errorf("%w: needs %d slots, cache has %d", ErrPayloadSize, slots, c.size)
+	}
+	return slots, nil
+}
+
+// nextID returns the ID following id on the ring.
+func (c *RingBufferCache) nextID(id int64) int64 {
+	if id >= int64(c.maxIDAlloc) {
+		return c.minIDAlloc
+	}
+	return id + 1
+}
+
+// advanceID returns the ID n positions after id on the ring.
+func (c *RingBufferCache) advanceID(id int64, n int64) int64 {
+	id += n % c.size
+	if id > int64(c.maxIDAlloc) {
+		id -= c.size
+	}
+	return id
+}
+
+// spanIDs lists the n consecutive ring IDs starting at id.
+func (c *RingBufferCache) spanIDs(id int64, n int64) []int64 {
+	ids := make([]int64, n)
+	for i := range ids {
+		ids[i] = id
+		id = c.nextID(id)
+	}
+	return ids
+}
+
+// encodeSlot fills buf with the header and data chunk for one slot and
+// returns the number of bytes that must be stored.
+func (c *RingBufferCache) encodeSlot(buf, chunk []byte, h slotHeader) int {
+	l := c.layout
+	if l.hasInfo {
+		info := uint32(h.length) | h.flags
+		if l.sumSize == 0 {
+			info |= flagWritten
+		}
+		binary.LittleEndian.PutUint32(buf[l.infoOff:], info)
+	}
+	if l.seqOff > 0 {
+		binary.LittleEndian.PutUint64(buf[l.seqOff:], h.seq)
+	}
+	if l.tsOff > 0 {
+		binary.LittleEndian.PutUint64(buf[l.tsOff:], uint64(h.ts))
+	}
+	end := l.hdrSize + copy(buf[l.hdrSize:], chunk)
+	sum := l.sum.sum(buf[l.sumSize:end])
+	if !l.hasInfo && h.flags&flagTombstone != 0 {
+		sum = l.sum.complement(sum)
+	}
+	l.sum.putSum(buf, sum)
+	return end
+}
+
+// decodeSlot verifies the checksum of buf and returns its header together
+// with the data bytes stored in the slot.
+func (c *RingBufferCache) decodeSlot(buf []byte) (slotHeader, []byte, error) {
+	l := c.layout
+	h := slotHeader{length: c.record}
+	if l.hasInfo {
+		info := binary.LittleEndian.Uint32(buf[l.infoOff:])
+		h.flags = info &^ infoLenMask &^ flagWritten
+		h.length = int(info & infoLenMask)
+		if l.sumSize == 0 && info&flagWritten == 0 {
+			return slotHeader{}, nil, fmt.Errorf("%w: slot never written", ErrCorrupted)
+		}
+	}
+	if l.seqOff > 0 {
+		h.seq = binary.LittleEndian.Uint64(buf[l.seqOff:])
+	}
+	if l.tsOff > 0 {
+		h.ts = int64(binary.LittleEndian.Uint64(buf[l.tsOff:]))
+	}
+	end := l.hdrSize + min(h.length, c.record)
+	if l.sumSize == 0 {
+		return h, buf[l.hdrSize:end], nil
+	}
+	sum, stored := l.sum.sum(buf[l.sumSize:end]), l.sum.storedSum(buf)
+	switch {
+	case sum == stored:
+	case !l.hasInfo && l.sum.complement(sum) == stored:
+		h.flags = flagTombstone
+	default:
+		return slotHeader{}, nil, fmt.Errorf("%w: %s mismatch", ErrCorrupted, l.sum.label())
+	}
+	return h, buf[l.hdrSize:end], nil
+}
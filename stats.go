@@ -4,10 +4,21 @@ import "sync/atomic"
 
 // Stats menyimpan statistik hit/miss cache.
 // HitRatio dalam persentase (0-100).
+//
+// Hits dan Misses hanya menghitung pembacaan oleh pemanggil; aktivitas
+// prefetch dihitung terpisah.
 type Stats struct {
 	Hits     uint64
 	Misses   uint64
 	HitRatio float64
+
+	PrefetchQueued  uint64 // blok yang masuk antrean prefetch
+	PrefetchDeduped uint64 // blok yang dilewati karena sudah antre atau baru di-warm
+	PrefetchDropped uint64 // blok yang dibuang karena antrean penuh
+	PrefetchSlots   uint64 // slot yang di-warm oleh worker
+
+	FlushErrors     uint64 // sync latar belakang (DurabilityPolicy) yang gagal
+	RetentionErrors uint64 // pemangkasan latar belakang (RetentionPolicy) yang gagal
 }
 
 // GetStats mengambil snapshot statistik tanpa lock berat.
@@ -19,20 +30,43 @@ func (c *RingBufferCache) GetStats() Stats {
 	if total > 0 {
 		ratio = float64(hits) / float64(total) * 100.0
 	}
-	return Stats{Hits: hits, Misses: misses, HitRatio: ratio}
+	st := Stats{Hits: hits, Misses: misses, HitRatio: ratio}
+	st.FlushErrors = atomic.LoadUint64(&c.flushErrors)
+	st.RetentionErrors = atomic.LoadUint64(&c.trimErrors)
+	if p := c.prefetcher; p != nil {
+		st.PrefetchQueued = atomic.LoadUint64(&p.queued)
+		st.PrefetchDeduped = atomic.LoadUint64(&p.deduped)
+		st.PrefetchDropped = atomic.LoadUint64(&p.dropped)
+		st.PrefetchSlots = atomic.LoadUint64(&p.slots)
+	}
+	return st
 }
 
 // ResetStats mengatur ulang penghitung hit/miss.
 func (c *RingBufferCache) ResetStats() {
 	atomic.StoreUint64(&c.statHits, 0)
 	atomic.StoreUint64(&c.statMisses, 0)
+	if p := c.prefetcher; p != nil {
+		atomic.StoreUint64(&p.queued, 0)
+		atomic.StoreUint64(&p.deduped, 0)
+		atomic.StoreUint64(&p.dropped, 0)
+		atomic.StoreUint64(&p.slots, 0)
+	}
 }
 
 // Size mengembalikan jumlah slot ID total.
-func (c *RingBufferCache) Size() int64 { return c.size }
+func (c *RingBufferCache) Size() int64 {
+	c.layoutMu.RLock()
+	defer c.layoutMu.RUnlock()
+	return c.size
+}
 
 // RecordSize mengembalikan ukuran setiap record (payload).
 func (c *RingBufferCache) RecordSize() int { return c.record }
 
 // ShardCount mengembalikan jumlah shard di disk.
-func (c *RingBufferCache) ShardCount() int { return len(c.shards) }
+func (c *RingBufferCache) ShardCount() int {
+	c.layoutMu.RLock()
+	defer c.layoutMu.RUnlock()
+	return len(c.shards)
+}
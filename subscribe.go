@@ -0,0 +1,182 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ErrLapped is reported by Subscribe when the producer overwrote records the
+// subscriber had not received yet.
+var ErrLapped = errors.New("subscriber lapped by producer")
+
+// Record is one item delivered by Subscribe.
+//
+// When Err is non-nil the other fields describe where the problem occurred:
+// an error wrapping ErrLapped means records were lost and delivery resumed
+// from the oldest record still in the ring; any other error is a failed read
+// of that record, which is then skipped.
+type Record struct {
+	ID      int64
+	Seq     uint64
+	Payload []byte
+	Time    time.Time // write time; zero unless the cache is Timestamped
+	Err     error
+}
+
+// headChanged returns a channel that is closed the next time WriteHead
+// commits a record. In a read-only cache the commits of the writer process
+// are picked up by watchShared, which is started on first use.
+func (c *RingBufferCache) headChanged() <-chan struct{} {
+	c.notifyMu.Lock()
+	defer c.notifyMu.Unlock()
+	if c.notifyCh == nil {
+		c.notifyCh = make(chan struct{})
+	}
+	if c.readOnly && c.shared != nil && c.watchDone == nil && !c.Closed() {
+		c.watchDone = make(chan struct{})
+		go c.watchShared()
+	}
+	return c.notifyCh
+}
+
+// notifyHead wakes everything waiting on headChanged.
+func (c *RingBufferCache) notifyHead() {
+	c.notifyMu.Lock()
+	if c.notifyCh != nil {
+		close(c.notifyCh)
+		c.notifyCh = nil
+	}
+	c.notifyMu.Unlock()
+}
+
+// commitHead publishes the claim first..last to Head() and subscribers. It
+// waits until every earlier claim is committed, so the committed range is
+// always a gap-free prefix of the ring.
+func (c *RingBufferCache) commitHead(first, last uint64) {
+	c.commitMu.Lock()
+	for atomic.LoadUint64(&c.commitSeq) < first-1 {
+		c.committed.Wait()
+	}
+	atomic.StoreUint64(&c.commitSeq, last)
+	c.publishCommit(last)
+	c.committed.Broadcast()
+	c.commitMu.Unlock()
+	c.notifyHead()
+}
+
+// Subscribe follows the ring and delivers every record committed by
+// WriteHead after fromID, in write order, blocking while there is nothing
+// new. fromID is the last ID the consumer has processed; pass Head() to
+// receive only new records or Tail()-1 to replay the whole ring (any value
+// below MinIDAlloc starts from the first record ever written).
+//
+// The channel is closed when ctx is cancelled or the cache is closed. A
+// subscriber that falls more than one ring behind receives a Record whose Err
+// wraps ErrLapped and then continues from the oldest record still available.
+func (c *RingBufferCache) Subscribe(ctx context.Context, fromID int64) (<-chan Record, error) {
+	if c.Closed() {
+		return nil, ErrClosed
+	}
+	next, err := c.seqAfter(fromID)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan Record)
+	go func() {
+		defer close(ch)
+		send := func(r Record) bool {
+			select {
+			case ch <- r:
+				return true
+			case <-ctx.Done():
+				return false
+			case <-c.done:
+				return false
+			}
+		}
+
+		for {
+			wait := c.headChanged()
+			for {
+				// the read is registered with Close, the send is not, so a
+				// slow consumer cannot block shutdown
+				if c.enter() != nil {
+					return
+				}
+				rec, after, ok := c.readFrom(next)
+				c.exit()
+				if !ok {
+					break
+				}
+				if !send(rec) {
+					return
+				}
+				next = after
+			}
+
+			select {
+			case <-wait:
+			case <-ctx.Done():
+				return
+			case <-c.done:
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// seqAfter returns the sequence number following the record at id; any id
+// below MinIDAlloc maps to the first record ever written.
+func (c *RingBufferCache) seqAfter(id int64) (uint64, error) {
+	c.layoutMu.RLock()
+	defer c.layoutMu.RUnlock()
+	if id < c.minIDAlloc {
+		return 1, nil
+	}
+	if _, err := c.absToRel(id); err != nil {
+		return 0, err
+	}
+	c.refreshShared()
+	if seq := c.ringSeq(id); seq > 0 {
+		return seq + 1, nil
+	}
+	// not reached by WriteHead yet: start right after its first-lap slot
+	return uint64(id-c.minIDAlloc) + 2, nil
+}
+
+// readFrom reads the first committed record at or after seq, skipping
+// continuation slots. It returns the record and the sequence to continue
+// from, or ok=false when nothing new is committed. After a lap the Record
+// carries an error wrapping ErrLapped and after points at the oldest record
+// still in the ring; deleted records and records expired by retention are
+// skipped silently and other read errors are returned in Record.Err and the
+// slot is skipped.
+func (c *RingBufferCache) readFrom(seq uint64) (rec Record, after uint64, ok bool) {
+	for seq <= atomic.LoadUint64(&c.commitSeq) {
+		id, h, payload, err := c.readSeq(seq)
+		switch {
+		case errors.Is(err, ErrOverwritten):
+			oldest := uint64(1)
+			if cur := atomic.LoadUint64(&c.seq); cur >= uint64(c.size) {
+				oldest = cur - uint64(c.size) + 1
+			}
+			lapped := fmt.Errorf("%w: missed seq %d..%d", ErrLapped, seq, oldest-1)
+			return Record{ID: id, Seq: seq, Err: lapped}, oldest, true
+		case errors.Is(err, errContinuation), errors.Is(err, ErrDeleted):
+			seq++
+		case errors.Is(err, ErrExpired):
+			seq = max(seq+1, c.oldestSeq())
+		case err != nil:
+			return Record{ID: id, Seq: seq, Err: err}, seq + 1, true
+		default:
+			rec := Record{ID: id, Seq: seq, Payload: payload, Time: c.recordTime(h)}
+			return rec, seq + uint64(c.slotsFor(h.length)), true
+		}
+	}
+	return Record{}, seq, false
+}
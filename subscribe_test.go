@@ -0,0 +1,85 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSubscribeFollowsHead(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MinIDAlloc = 1
+	opts.MaxIDAlloc = 5
+	cache, _ := newTestCacheWithOpts(t, 5, 4, opts)
+	defer cache.Close()
+
+	if _, err := cache.WriteHead([]byte("old!"), false); err != nil {
+		t.Fatalf("WriteHead: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sub, err := cache.Subscribe(ctx, cache.Head())
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	// 8 writes wrap the 5-slot ring; subscriber must see them in order
+	go func() {
+		for i := 0; i < 8; i++ {
+			cache.WriteHead([]byte{'n', 'e', 'w', byte('0' + i)}, false)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	for i := 0; i < 8; i++ {
+		var r Record
+		select {
+		case r = <-sub:
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for record %d", i)
+		}
+		if r.Err != nil {
+			if errors.Is(r.Err, ErrLapped) {
+				t.Skipf("subscriber lapped on a slow machine: %v", r.Err)
+			}
+			t.Fatalf("record %d: %v", i, r.Err)
+		}
+		if want := byte('0' + i); r.Payload[3] != want || r.Seq != uint64(i+2) {
+			t.Fatalf("record %d: got %q seq %d", i, r.Payload, r.Seq)
+		}
+	}
+}
+
+func TestSubscribeReportsLap(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MinIDAlloc = 1
+	opts.MaxIDAlloc = 4
+	cache, _ := newTestCacheWithOpts(t, 4, 1, opts)
+	defer cache.Close()
+
+	for i := 0; i < 10; i++ {
+		if _, err := cache.WriteHead([]byte{byte(i)}, false); err != nil {
+			t.Fatalf("WriteHead: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sub, err := cache.Subscribe(ctx, 0) // from the very first record
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	r := <-sub
+	if !errors.Is(r.Err, ErrLapped) {
+		t.Fatalf("expected ErrLapped, got %+v", r)
+	}
+	for want := byte(6); want < 10; want++ {
+		r := <-sub
+		if r.Err != nil || r.Payload[0] != want {
+			t.Fatalf("expected record %d after lap, got %+v", want, r)
+		}
+	}
+}
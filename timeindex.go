@@ -0,0 +1,150 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Timestamped caches store the write time of every record in its slot
+// header. WriteHead stamps records with a clock that never runs backwards
+// (a wall clock step back repeats the last timestamp), so timestamps are
+// non-decreasing from Tail() to Head() and a time lookup is a binary search
+// over the sequence numbers of the live window, wrap included.
+
+var errNotTimestamped = errors.New("cache is not timestamped")
+
+// stamp returns the timestamp for a record written now, or 0 when the cache
+// is not timestamped.
+func (c *RingBufferCache) stamp() int64 {
+	if c.layout.tsOff == 0 {
+		return 0
+	}
+	return c.advanceClock(c.now().UnixNano())
+}
+
+// advanceClock returns max(ts, last timestamp handed out) and records it.
+func (c *RingBufferCache) advanceClock(ts int64) int64 {
+	for {
+		last := atomic.LoadInt64(&c.lastTS)
+		if ts <= last {
+			return last
+		}
+		if atomic.CompareAndSwapInt64(&c.lastTS, last, ts) {
+			return ts
+		}
+	}
+}
+
+// recordTime converts a slot timestamp to a time.Time (zero when absent).
+func (c *RingBufferCache) recordTime(h slotHeader) time.Time {
+	if c.layout.tsOff == 0 || h.ts == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, h.ts)
+}
+
+// headerAt decodes the header of the slot holding seq.
+func (c *RingBufferCache) headerAt(seq uint64) (slotHeader, error) {
+	id := c.idForSeq(seq)
+	shard, offset, err := c.slotAt(id)
+	if err != nil {
+		return slotHeader{}, err
+	}
+	bp := c.getBufFromPool()
+	defer c.returnBufToPool(bp)
+	buf := *bp
+
+	m := c.lock(id)
+	m.RLock()
+	err = shard.readSlot(buf, offset)
+	m.RUnlock()
+	if err != nil {
+		return slotHeader{}, c.recordErr(id, shard, offset, err)
+	}
+	h, _, err := c.decodeSlot(buf)
+	switch {
+	case err != nil:
+		return h, c.recordErr(id, shard, offset, err)
+	case c.layout.seqOff > 0 && h.seq > seq:
+		err := fmt.Errorf("%w: seq %d, slot now holds seq %d", ErrOverwritten, seq, h.seq)
+		return h, c.recordErr(id, shard, offset, err)
+	}
+	return h, nil
+}
+
+// seekTime returns the first sequence in the live window whose slot was
+// written at or after ts, or commitSeq+1 when every record is older.
+func (c *RingBufferCache) seekTime(ts int64) (uint64, error) {
+	hi := atomic.LoadUint64(&c.commitSeq) + 1
+	lo := c.oldestSeq()
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		h, err := c.headerAt(mid)
+		if errors.Is(err, ErrOverwritten) {
+			// the producer moved the window while we searched
+			lo = max(mid+1, c.oldestSeq())
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if h.ts < ts {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+// SeekTime returns the ID of the oldest live record written at or after t.
+// It returns an error wrapping ErrOutOfRange when every record is older
+// than t. The cache must be Timestamped.
+func (c *RingBufferCache) SeekTime(t time.Time) (int64, error) {
+	if c.layout.tsOff == 0 {
+		return 0, errNotTimestamped
+	}
+	if err := c.enter(); err != nil {
+		return 0, err
+	}
+	defer c.exit()
+
+	seq, err := c.seekTime(t.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	rec, _, ok := c.readFrom(seq)
+	if !ok {
+		return 0, fmt.Errorf("%w: no record at or after %s", ErrOutOfRange, t.Format(time.RFC3339Nano))
+	}
+	return rec.ID, nil
+}
+
+// RangeByTime returns the live records written in [from, to), oldest first.
+// Records that cannot be read are included with Err set, as in Subscribe.
+// The cache must be Timestamped.
+func (c *RingBufferCache) RangeByTime(from, to time.Time) ([]Record, error) {
+	if c.layout.tsOff == 0 {
+		return nil, errNotTimestamped
+	}
+	if err := c.enter(); err != nil {
+		return nil, err
+	}
+	defer c.exit()
+
+	seq, err := c.seekTime(from.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	var out []Record
+	for {
+		rec, after, ok := c.readFrom(seq)
+		if !ok || (rec.Err == nil && !rec.Time.Before(to)) {
+			return out, nil
+		}
+		out = append(out, rec)
+		seq = after
+	}
+}
@@ -0,0 +1,95 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// newTimedCache returns a timestamped cache whose clock is read from *now.
+func newTimedCache(t *testing.T, slots int64, variable bool) (*RingBufferCache, string, *time.Time) {
+	t.Helper()
+	opts := DefaultOptions()
+	opts.MaxIDAlloc = slots
+	opts.Timestamped = true
+	opts.VariableLength = variable
+	cache, base := newTestCacheWithOpts(t, slots, 4, opts)
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+	return cache, base, &now
+}
+
+func TestSeekTimeWrappedRing(t *testing.T) {
+	cache, _, now := newTimedCache(t, 8, false)
+	defer cache.Close()
+	start := *now
+	for i := 0; i < 12; i++ { // seq 5..12 survive, IDs wrap after 8
+		*now = start.Add(time.Duration(i) * time.Minute)
+		cache.WriteHead([]byte(fmt.Sprintf("r%03d", i)), false)
+	}
+
+	cases := []struct {
+		at   time.Duration
+		want int64
+	}{
+		{0, 5},                           // before the window: oldest record
+		{6 * time.Minute, 7},             // exact hit
+		{7*time.Minute + time.Second, 1}, // between records, after the wrap
+		{11 * time.Minute, 4},            // newest record
+	}
+	for _, tc := range cases {
+		id, err := cache.SeekTime(start.Add(tc.at))
+		if err != nil || id != tc.want {
+			t.Fatalf("SeekTime(+%v) = %d, %v; want %d", tc.at, id, err, tc.want)
+		}
+	}
+	if _, err := cache.SeekTime(start.Add(time.Hour)); !errors.Is(err, ErrOutOfRange) {
+		t.Fatalf("SeekTime after head: %v", err)
+	}
+
+	recs, err := cache.RangeByTime(start.Add(6*time.Minute), start.Add(9*time.Minute))
+	if err != nil || len(recs) != 3 {
+		t.Fatalf("RangeByTime: %d records, %v", len(recs), err)
+	}
+	for i, r := range recs {
+		if want := fmt.Sprintf("r%03d", 6+i); string(r.Payload) != want || !r.Time.Equal(start.Add(time.Duration(6+i)*time.Minute)) {
+			t.Fatalf("record %d = %q at %v", i, r.Payload, r.Time)
+		}
+	}
+}
+
+func TestTimestampsMonotonicAcrossRestart(t *testing.T) {
+	cache, base, now := newTimedCache(t, 8, true)
+	cache.WriteHead([]byte("first"), false) // spans two slots
+	later := *now
+	*now = now.Add(-time.Hour) // wall clock stepped back
+	cache.WriteHead([]byte("b"), false)
+	recs, _ := cache.RangeByTime(later, later.Add(time.Second))
+	if len(recs) != 2 || !recs[1].Time.Equal(later) {
+		t.Fatalf("clock step back not clamped: %+v", recs)
+	}
+	cache.Close()
+
+	reopened := reopenTestCache(t, base, 4)
+	defer reopened.Close()
+	if !reopened.options.Timestamped {
+		t.Fatalf("Timestamped not restored from .cfg")
+	}
+	reopened.now = func() time.Time { return later.Add(-time.Minute) }
+	id, _ := reopened.WriteHead([]byte("c"), false)
+	if got, err := reopened.SeekTime(later); err != nil || got != 1 {
+		t.Fatalf("SeekTime after restart = %d, %v", got, err)
+	}
+	if recs, _ := reopened.RangeByTime(later, later.Add(time.Second)); len(recs) != 3 || recs[2].ID != id {
+		t.Fatalf("restart broke time order: %+v", recs)
+	}
+}
+
+func TestSeekTimeRequiresTimestamps(t *testing.T) {
+	cache, _ := newTestCache(t, 8, 4)
+	defer cache.Close()
+	if _, err := cache.SeekTime(time.Now()); err == nil {
+		t.Fatalf("expected error on a cache without timestamps")
+	}
+}
@@ -0,0 +1,135 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Deleted records are tombstone slots (see slot.go). Read returns ErrDeleted
+// for them while BulkRead, Subscribe, cursors and time lookups skip them. A
+// tombstone keeps the sequence number and timestamp of the slot it replaces,
+// so the ring stays ordered.
+//
+// DeleteRange over at least punchHoleMin bytes also hands the data pages of
+// every tombstone back to the filesystem with fallocate(PUNCH_HOLE). Only
+// whole pages inside a slot are punched, so this frees space only when slots
+// are at least a page long; filesystems without hole punching are left as is.
+
+const (
+	punchHoleMin  = 1 << 20 // bytes a DeleteRange must cover before punching
+	deleteChunk   = 1 << 20 // bytes of tombstones encoded per batch
+	fallocPunchFl = unix.FALLOC_FL_PUNCH_HOLE | unix.FALLOC_FL_KEEP_SIZE
+)
+
+// deleteRun replaces the n slots starting at id with tombstones and returns
+// the ranges that must be synced to make the deletion durable.
+func (c *RingBufferCache) deleteRun(id, n int64, punch bool) (dirtySet, error) {
+	ids := c.spanIDs(id, n)
+	unlock := c.lockSpan(ids, true)
+	defer unlock()
+
+	rec := int64(c.diskRec)
+	hdr := int64(c.layout.hdrSize)
+	page := int64(os.Getpagesize())
+	buf := make([]byte, n*rec)
+	var dirty dirtySet
+	for start := int64(0); start < n; {
+		s, off, err := c.slotAt(ids[start])
+		if err != nil {
+			return dirty, err
+		}
+		end := start + 1
+		for end < n && ids[end] == ids[end-1]+1 && ids[end]-c.minIDAlloc < s.offset+s.size {
+			end++
+		}
+		run := buf[start*rec : end*rec]
+		if c.layout.tsOff > 0 {
+			// the old timestamps keep the ring ordered by time
+			if err := s.readSlot(run, off); err != nil {
+				return dirty, c.recordErr(ids[start], s, off, err)
+			}
+		}
+		for k := start; k < end; k++ {
+			slot := buf[k*rec : (k+1)*rec]
+			h := slotHeader{flags: flagTombstone, seq: c.seqForID(ids[k])}
+			if c.layout.tsOff > 0 {
+				if old, _, err := c.decodeSlot(slot); err == nil {
+					h.ts = old.ts
+				}
+			}
+			if !c.layout.hasInfo {
+				h.length = c.record
+			}
+			clear(slot)
+			c.encodeSlot(slot, slot[hdr:hdr+int64(h.length)], h)
+		}
+		if err := s.writeSlot(run, off); err != nil {
+			return dirty, c.recordErr(ids[start], s, off, err)
+		}
+		dirty.add(s, off, int64(len(run)))
+
+		for o := off; punch && o < off+int64(len(run)); o += rec {
+			lo := (o + hdr + page - 1) &^ (page - 1)
+			hi := (o + rec) &^ (page - 1)
+			if hi <= lo {
+				break // slots shorter than a page hold no whole page
+			}
+			if err := s.punchHole(lo, hi-lo); err != nil {
+				return dirty, err
+			}
+		}
+		start = end
+	}
+	return dirty, nil
+}
+
+// punchHole deallocates n bytes at off, which then read back as zeros.
+func (s *shard) punchHole(off, n int64) error {
+	err := unix.Fallocate(int(s.file.Fd()), fallocPunchFl, off, n)
+	switch {
+	case err == nil, errors.Is(err, unix.EOPNOTSUPP), errors.Is(err, unix.ENOSYS):
+		return nil
+	}
+	return &ShardError{Op: "fallocate", Shard: s.index, Path: s.filePath, Err: closedErr(err)}
+}
+
+// DeleteRange deletes the slots with IDs from..to (inclusive) as Delete
+// does for one ID. In VariableLength mode the range counts slots, so a record
+// that only partly lies in it is left broken. Like Delete it syncs before
+// returning unless the DurabilityPolicy is DurabilityOS.
+func (c *RingBufferCache) DeleteRange(from, to int64) error {
+	if err := c.enterWrite(); err != nil {
+		return err
+	}
+	defer c.exit()
+	if from > to {
+		return fmt.Errorf("%w: empty range %d..%d", ErrOutOfRange, from, to)
+	}
+	if _, _, err := c.slotAt(from); err != nil {
+		return err
+	}
+	if _, _, err := c.slotAt(to); err != nil {
+		return err
+	}
+
+	total := to - from + 1
+	punch := total*int64(c.diskRec) >= punchHoleMin
+	per := max(1, deleteChunk/int64(c.diskRec))
+	var dirty dirtySet
+	for id := from; id <= to; id += per {
+		d, err := c.deleteRun(id, min(per, to-id+1), punch)
+		for _, r := range d {
+			dirty.add(r.s, r.lo, r.hi-r.lo)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if c.options.Durability.Mode == DurabilityOS {
+		return nil
+	}
+	return c.syncDirty(dirty)
+}
@@ -0,0 +1,115 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func newVariableCache(t *testing.T, slots int64, recordSize int) (*RingBufferCache, string) {
+	t.Helper()
+	opts := DefaultOptions()
+	opts.VariableLength = true
+	return newTestCacheWithOpts(t, slots, recordSize, opts)
+}
+
+func TestVariableLengthRoundTrip(t *testing.T) {
+	cache, _ := newVariableCache(t, 20, 8)
+	defer cache.Close()
+
+	cases := map[int64][]byte{
+		1:  {},
+		2:  []byte("abc"),
+		3:  []byte("exactly8"),
+		5:  []byte("this spans three slots"), // 22 bytes
+		19: []byte("wraps past MaxIDAlloc"),  // 21 bytes: slots 19, 20, 1
+	}
+	for _, id := range []int64{1, 2, 3, 5} {
+		if err := cache.Write(id, cases[id], false); err != nil {
+			t.Fatalf("write %d: %v", id, err)
+		}
+	}
+	for _, id := range []int64{1, 2, 3, 5} {
+		got, err := cache.Read(id)
+		if err != nil {
+			t.Fatalf("read %d: %v", id, err)
+		}
+		if !bytes.Equal(got, cases[id]) {
+			t.Fatalf("id %d: got %q want %q", id, got, cases[id])
+		}
+	}
+	if _, err := cache.Read(6); err == nil {
+		t.Fatalf("expected error reading continuation slot")
+	}
+
+	if err := cache.Write(19, cases[19], true); err != nil {
+		t.Fatalf("write wrapping record: %v", err)
+	}
+	got, err := cache.Read(19)
+	if err != nil || !bytes.Equal(got, cases[19]) {
+		t.Fatalf("wrapping record: got %q, %v", got, err)
+	}
+}
+
+func TestVariableLengthWriteHeadAndBulk(t *testing.T) {
+	cache, _ := newVariableCache(t, 30, 4)
+	defer cache.Close()
+
+	id, err := cache.WriteHead([]byte("0123456789"), false) // 3 slots
+	if err != nil {
+		t.Fatalf("WriteHead: %v", err)
+	}
+	if id != 1 || cache.Head() != 3 {
+		t.Fatalf("expected id 1 and head 3, got id %d head %d", id, cache.Head())
+	}
+	if id, _ = cache.WriteHead([]byte("x"), false); id != 4 {
+		t.Fatalf("expected next record at 4, got %d", id)
+	}
+
+	payloads := [][]byte{[]byte("short"), []byte("a"), []byte("a bit longer")}
+	if err := cache.BulkWrite(10, payloads, true); err != nil {
+		t.Fatalf("bulk write: %v", err)
+	}
+	got, err := cache.BulkRead(10, len(payloads))
+	if err != nil {
+		t.Fatalf("bulk read: %v", err)
+	}
+	for i := range payloads {
+		if !bytes.Equal(got[i], payloads[i]) {
+			t.Fatalf("record %d: got %q want %q", i, got[i], payloads[i])
+		}
+	}
+}
+
+func TestVariableLengthPersistedInConfig(t *testing.T) {
+	cache, base := newVariableCache(t, 10, 8)
+	if err := cache.Write(1, []byte("persisted record"), true); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	cache.Close()
+
+	raw, err := os.ReadFile(base + ".cfg")
+	if err != nil {
+		t.Fatalf("read cfg: %v", err)
+	}
+	var cfg persistedConfig
+	if err := json.Unmarshal(raw, &cfg); err != nil || !cfg.VariableLength {
+		t.Fatalf("cfg does not record variable-length mode: %s", raw)
+	}
+
+	// reopen without the option: the persisted mode must win
+	opts := DefaultOptions()
+	opts.UseMmap = false
+	opts.ShardCount = 1
+	opts.RecordSize = 8
+	reopened, err := NewRingBufferCacheWithOptions(base, opts)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	got, err := reopened.Read(1)
+	if err != nil || string(got) != "persisted record" {
+		t.Fatalf("read after reopen: %q, %v", got, err)
+	}
+}
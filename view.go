@@ -0,0 +1,169 @@
+package archive
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// View calls fn with the payload of the record at id. For mmap shards the
+// slice aliases the mapping and the record's read lock is held while fn
+// runs, so a single-slot read neither copies nor allocates. fn must not keep
+// the slice after it returns, modify it, or write to the cache.
+//
+// Plain-file shards and records spanning several slots are read into a
+// temporary buffer first, and compressed or encrypted records (see Codec and
+// Cipher) are decoded into one. The error returned by fn is passed through.
+func (c *RingBufferCache) View(id int64, fn func([]byte) error) error {
+	if err := c.enter(); err != nil {
+		return err
+	}
+	defer c.exit()
+
+	shard, offset, err := c.slotAt(id)
+	if err != nil {
+		return err
+	}
+	bp := c.getBufFromPool()
+	defer c.returnBufToPool(bp)
+
+	h, data, m, err := c.lockSlot(shard, offset, id, *bp)
+	if err != nil {
+		atomic.AddUint64(&c.statMisses, 1)
+		return err
+	}
+	if err := c.checkExpired(id, c.ringSeq(id), h, shard, offset); err != nil {
+		m.RUnlock()
+		return err
+	}
+	if c.transformed() {
+		m.RUnlock()
+		_, out, err := c.readRecord(shard, offset, id)
+		if err != nil {
+			return err
+		}
+		c.readAhead(id, h.length)
+		return fn(out)
+	}
+	if h.length > c.record {
+		m.RUnlock()
+		_, out, err := c.readSpan(id, *bp, nil)
+		if err != nil {
+			atomic.AddUint64(&c.statMisses, 1)
+			return err
+		}
+		atomic.AddUint64(&c.statHits, 1)
+		c.readAhead(id, len(out))
+		return fn(out)
+	}
+
+	atomic.AddUint64(&c.statHits, 1)
+	err = func() error {
+		defer m.RUnlock()
+		return fn(data)
+	}()
+	c.readAhead(id, h.length)
+	return err
+}
+
+// ReadInto copies the payload of the record at id into dst and returns its
+// length. Single-slot records are read without allocating; compressed ones
+// are decoded straight into dst. When dst is too short it returns the needed
+// length and an error wrapping io.ErrShortBuffer.
+func (c *RingBufferCache) ReadInto(id int64, dst []byte) (int, error) {
+	if err := c.enter(); err != nil {
+		return 0, err
+	}
+	defer c.exit()
+
+	shard, offset, err := c.slotAt(id)
+	if err != nil {
+		return 0, err
+	}
+	bp := c.getBufFromPool()
+	defer c.returnBufToPool(bp)
+
+	h, data, m, err := c.lockSlot(shard, offset, id, *bp)
+	if err != nil {
+		atomic.AddUint64(&c.statMisses, 1)
+		return 0, err
+	}
+	if err := c.checkExpired(id, c.ringSeq(id), h, shard, offset); err != nil {
+		m.RUnlock()
+		return 0, err
+	}
+	if c.transformed() {
+		return c.readFramedInto(id, shard, offset, h, data, m, *bp, dst)
+	}
+	if h.length > len(dst) {
+		m.RUnlock()
+		err := fmt.Errorf("%w: record is %d bytes, dst holds %d", io.ErrShortBuffer, h.length, len(dst))
+		return h.length, c.recordErr(id, shard, offset, err)
+	}
+
+	n := h.length
+	if n <= c.record {
+		copy(dst, data)
+		m.RUnlock()
+	} else {
+		m.RUnlock()
+		_, out, err := c.readSpan(id, *bp, dst)
+		if err != nil {
+			atomic.AddUint64(&c.statMisses, 1)
+			return 0, err
+		}
+		if len(out) > len(dst) {
+			// the record was replaced by a longer one while we read it
+			err := fmt.Errorf("%w: record is %d bytes, dst holds %d", io.ErrShortBuffer, len(out), len(dst))
+			return len(out), c.recordErr(id, shard, offset, err)
+		}
+		n = copy(dst, out) // no-op unless readSpan had to allocate
+	}
+	atomic.AddUint64(&c.statHits, 1)
+	c.readAhead(id, n)
+	return n, nil
+}
+
+// readFramedInto is ReadInto for compressed or encrypted records. data and m are the
+// first slot and lock returned by lockSlot.
+func (c *RingBufferCache) readFramedInto(id int64, shard *shard, offset int64, h slotHeader, data []byte, m *sync.RWMutex, buf, dst []byte) (int, error) {
+	stored := data
+	if h.length > c.record {
+		m.RUnlock()
+		var err error
+		if _, stored, err = c.readSpan(id, buf, nil); err != nil {
+			atomic.AddUint64(&c.statMisses, 1)
+			return 0, err
+		}
+	} else {
+		defer m.RUnlock()
+	}
+
+	var err error
+	if c.cipher != nil {
+		stored, err = c.openRecord(id, stored)
+	}
+	var n int
+	if err == nil {
+		n, err = c.storedPayloadLen(stored)
+	}
+	if err == nil && n > len(dst) {
+		err := fmt.Errorf("%w: record is %d bytes, dst holds %d", io.ErrShortBuffer, n, len(dst))
+		return n, c.recordErr(id, shard, offset, err)
+	}
+	if err == nil {
+		if c.codec != nil {
+			_, err = decodeRecord(dst[:0], stored)
+		} else {
+			copy(dst, stored)
+		}
+	}
+	if err != nil {
+		atomic.AddUint64(&c.statMisses, 1)
+		return 0, c.recordErr(id, shard, offset, err)
+	}
+	atomic.AddUint64(&c.statHits, 1)
+	c.readAhead(id, h.length)
+	return n, nil
+}
@@ -0,0 +1,81 @@
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestViewAndReadIntoDoNotAllocate(t *testing.T) {
+	for _, mmap := range []bool{true, false} {
+		opts := DefaultOptions()
+		opts.UseMmap = mmap
+		opts.ShardCount = 1
+		opts.RecordSize = 16
+		opts.MaxIDAlloc = 8
+		opts.PrefetchSize = 0
+		cache, err := NewRingBufferCacheWithOptions(filepath.Join(t.TempDir(), "cache.data"), opts)
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		payload := []byte("0123456789abcdef")
+		cache.Write(3, payload, false)
+
+		var seen []byte
+		view := func(p []byte) error {
+			seen = p
+			return nil
+		}
+		if err := cache.View(3, view); err != nil || !bytes.Equal(seen, payload) {
+			t.Fatalf("View = %q, %v", seen, err)
+		}
+		dst := make([]byte, 16)
+		if n, err := cache.ReadInto(3, dst); err != nil || n != 16 || !bytes.Equal(dst, payload) {
+			t.Fatalf("ReadInto = %d, %v", n, err)
+		}
+
+		if mmap {
+			if allocs := testing.AllocsPerRun(100, func() { cache.View(3, view) }); allocs != 0 {
+				t.Errorf("View on mmap allocates %.0f times per call", allocs)
+			}
+		}
+		if allocs := testing.AllocsPerRun(100, func() { cache.ReadInto(3, dst) }); allocs != 0 {
+			t.Errorf("ReadInto (mmap=%v) allocates %.0f times per call", mmap, allocs)
+		}
+		cache.Close()
+	}
+}
+
+func TestReadIntoShortBufferAndSpans(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxIDAlloc = 8
+	opts.VariableLength = true
+	cache, _ := newTestCacheWithOpts(t, 8, 4, opts)
+	defer cache.Close()
+	payload := []byte("spans three slots")
+	cache.Write(2, payload, false)
+
+	n, err := cache.ReadInto(2, make([]byte, 4))
+	if !errors.Is(err, io.ErrShortBuffer) || n != len(payload) {
+		t.Fatalf("short dst: %d, %v", n, err)
+	}
+	dst := make([]byte, 32)
+	if n, err := cache.ReadInto(2, dst); err != nil || !bytes.Equal(dst[:n], payload) {
+		t.Fatalf("ReadInto = %q, %v", dst[:n], err)
+	}
+	var got []byte
+	err = cache.View(2, func(p []byte) error {
+		got = append(got, p...)
+		return io.EOF
+	})
+	if err != io.EOF || !bytes.Equal(got, payload) {
+		t.Fatalf("View = %q, %v", got, err)
+	}
+
+	cache.Delete(2)
+	if err := cache.View(2, func([]byte) error { return nil }); !errors.Is(err, ErrDeleted) {
+		t.Fatalf("View of deleted record: %v", err)
+	}
+}